@@ -0,0 +1,53 @@
+package graviton
+
+import "fmt"
+
+// Backend is the storage underneath a Store, disk and memory stores use the built in disk and memory backends and
+// arbitrary storage ( object stores, remote block devices etc) can be plugged in using NewStoreWithBackend.
+//
+// Data is addressed by (findex, fpos) where findex is a chunk number and fpos is the offset within the chunk.
+// (0,0) is reserved to mean invalid and must never be returned from Write. Versions are 1 based and each version
+// record is a (findex, fpos) pair of a version root. The store serializes Write and version calls, ReadAt may be
+// called concurrently with them.
+type Backend interface {
+	// Write appends the buffer and returns the position at which it was written
+	Write(buf []byte) (findex, fpos uint32, err error)
+
+	// ReadAt reads len(buf) bytes (or less if the chunk ends) from the specified position.
+	// io.EOF may be returned alongwith partially filled buffer, if the chunk ends
+	ReadAt(findex, fpos uint32, buf []byte) (int, error)
+
+	// WriteVersionData stores the position of the version root for the specific version
+	WriteVersionData(version uint64, findex, fpos uint32) error
+
+	// ReadVersionData reads back the position of the version root for the specific version
+	ReadVersionData(version uint64) (findex, fpos uint32, err error)
+
+	// HighestVersion returns the highest version for which version data has been written, 0 if none
+	HighestVersion() (uint64, error)
+
+	// ReadHeader returns the store header last written by WriteHeader, nil if none has been written
+	ReadHeader() ([]byte, error)
+
+	// WriteHeader records the store header, an earlier header must be replaced atomically
+	WriteHeader(buf []byte) error
+
+	// Close releases all resources held by the backend
+	Close() error
+}
+
+// open a store backed by a user supplied storage backend
+// options are optional, only the first one is used. Hash function and format version are recorded in the store
+// header using the backend, and are checked every time the store is opened.
+// Backends which hold data but no header were written before headers were recorded, they are taken to use the
+// requested hash function and get their header written on open.
+func NewStoreWithBackend(backend Backend, options ...StoreOptions) (*Store, error) {
+	if backend == nil {
+		return nil, fmt.Errorf("backend cannot be nil")
+	}
+	s := &Store{backend: backend}
+	if len(options) >= 1 {
+		s.options = options[0]
+	}
+	return s.init()
+}
@@ -0,0 +1,250 @@
+package graviton
+
+import "os"
+import "fmt"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/binary"
+
+import "golang.org/x/xerrors"
+
+// data file of a disk store
+type datafile struct {
+	handle *os.File
+	size   uint32
+}
+
+// diskBackend keeps data in files of upto MAX_FILE_SIZE named d/c/b/a.dfs below the store directory, and version
+// records in version_root.bin. Sync policies, recovery on open, compaction and restore work on its files directly,
+// so they are only available to disk stores.
+type diskBackend struct {
+	dir string
+
+	files  map[uint32]*datafile
+	findex uint32
+
+	versionrootfile *os.File // each version is 8 bytes and stores the file index and fpos
+
+	deferred         bool   // version records are held back till data is synced, see Store.flush
+	pending_versions []byte // version records which will be written to disk once data is synced
+	pending_start    uint64 // version number of first pending record
+	synced_findex    uint32 // files before this index have been synced
+}
+
+func new_disk_backend(dir string) *diskBackend {
+	return &diskBackend{dir: dir, files: map[uint32]*datafile{}}
+}
+
+// 4 billion files  each of 4 GB seems to be enough for quite some time, we will run out of handles much earlier
+// note that the structure is independant of these pointers and can thus be extended at any point in time in future
+func (b *diskBackend) uint_to_filename(n uint32) string {
+	return filepath.Join(b.dir, filepath.FromSlash(chunk_name(n)))
+}
+
+// relative name of a data chunk in d/c/b/a.dfs format, always uses / as separator
+// this layout is shared by disk store and object store backends
+func chunk_name(n uint32) string {
+	d, c, b, a := n>>24, ((n >> 16) & 0xff), ((n >> 8) & 0xff), n
+	return fmt.Sprintf("%d/%d/%d/%d.dfs", d, c, b, a)
+}
+
+// open version records and all data files, first data file is created for new stores
+// we may need to increase file handles
+func (b *diskBackend) open() error {
+	if file_handle, err := os.OpenFile(filepath.Join(b.dir, "version_root.bin"), os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return xerrors.Errorf("%w:  index %d, filename %s", err, b.findex, b.uint_to_filename(uint32(b.findex)))
+	} else {
+		b.versionrootfile = file_handle
+	}
+
+	for i := uint32(0); i < (4*1024*1024*1024)-1; i++ {
+		filename := b.uint_to_filename(uint32(i))
+
+		finfo, err := os.Stat(filename)
+		if os.IsNotExist(err) { // path/to/whatever does not exist
+			break
+		}
+
+		if finfo != nil && finfo.IsDir() {
+			return fmt.Errorf("expected file but found directory at path %s", filename)
+		}
+
+		file_handle, err := os.OpenFile(filename, os.O_RDWR, 0600)
+		if err != nil {
+			return fmt.Errorf("%s: filename:%s", err, filename)
+		}
+
+		b.files[i] = &datafile{handle: file_handle, size: uint32(finfo.Size())}
+		b.findex = i
+	}
+
+	if len(b.files) == 0 {
+		return b.create_first_file()
+	}
+	return nil
+}
+
+func (b *diskBackend) create_first_file() error {
+	err := os.MkdirAll(filepath.Dir(b.uint_to_filename(0)), 0700)
+	if err != nil {
+		return fmt.Errorf("direction creation err %s  filename %s \n", err, b.uint_to_filename(0))
+	}
+	if file_handle, err := os.OpenFile(b.uint_to_filename(0), os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return xerrors.Errorf("%w:  index %d, filename %s", err, 0, b.uint_to_filename(uint32(0)))
+	} else {
+		file_handle.Write([]byte{0x0}) // write a byte so as mark 0,0 as invalid
+		b.findex = 0
+		b.files[b.findex] = &datafile{handle: file_handle, size: uint32(1)}
+	}
+	return nil
+}
+
+// create data file findex, its directories are created if required
+func (b *diskBackend) create_file(findex uint32) (*datafile, error) {
+	filename := b.uint_to_filename(findex)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, fmt.Errorf("direction creation err %s  filename %s \n", err, filename)
+	}
+	file_handle, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, xerrors.Errorf("%w:  index %d, filename %s", err, findex, filename)
+	}
+	b.files[findex] = &datafile{handle: file_handle}
+	return b.files[findex], nil
+}
+
+func (b *diskBackend) Write(buf []byte) (uint32, uint32, error) {
+	cfile, ok := b.files[b.findex]
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid file structures")
+	}
+
+	// check whether we need to open a new file or overflowing
+	if cfile.size+uint32(len(buf)) > MAX_FILE_SIZE || cfile.size+uint32(len(buf)) < cfile.size {
+		var err error
+		if cfile, err = b.create_file(b.findex + 1); err != nil {
+			return 0, 0, err
+		}
+		b.findex++
+	}
+
+	pos := cfile.size
+	done, err := cfile.handle.WriteAt(buf, int64(pos))
+	cfile.size += uint32(done)
+	return b.findex, pos, err
+}
+
+// write data at a specific position, used while restoring backups so as data retains its original position
+// all files upto findex are created if required
+func (b *diskBackend) WriteAt(findex, fpos uint32, buf []byte) error {
+	for ; b.findex < findex; b.findex++ {
+		if _, err := b.create_file(b.findex + 1); err != nil {
+			return err
+		}
+	}
+
+	cfile, ok := b.files[findex]
+	if !ok {
+		return fmt.Errorf("findex not available")
+	}
+	if _, err := cfile.handle.WriteAt(buf, int64(fpos)); err != nil {
+		return err
+	}
+	if fpos+uint32(len(buf)) > cfile.size {
+		cfile.size = fpos + uint32(len(buf))
+	}
+	return nil
+}
+
+func (b *diskBackend) ReadAt(findex, fpos uint32, buf []byte) (int, error) {
+	cfile, ok := b.files[findex]
+	if !ok {
+		return 0, fmt.Errorf("findex not available")
+	}
+	return cfile.handle.ReadAt(buf, int64(fpos))
+}
+
+// versions are 1 based, records are held back if data has not been synced yet
+func (b *diskBackend) WriteVersionData(version uint64, findex, fpos uint32) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint32(buf[0:], findex)
+	binary.LittleEndian.PutUint32(buf[4:], fpos)
+
+	if !b.deferred {
+		_, err := b.versionrootfile.WriteAt(buf[:], int64((version-1)*8))
+		return err
+	}
+	if len(b.pending_versions) == 0 {
+		b.pending_start = version
+	}
+	if version != b.pending_start+uint64(len(b.pending_versions)/8) {
+		return fmt.Errorf("version %d is not in sequence", version)
+	}
+	b.pending_versions = append(b.pending_versions, buf[:]...)
+	return nil
+}
+
+// versions are 1 based
+func (b *diskBackend) ReadVersionData(version uint64) (findex uint32, fpos uint32, err error) {
+	var buf [8]byte
+	if len(b.pending_versions) > 0 && version >= b.pending_start {
+		offset := (version - b.pending_start) * 8
+		if offset+8 > uint64(len(b.pending_versions)) {
+			return 0, 0, fmt.Errorf("invalid version %d", version)
+		}
+		copy(buf[:], b.pending_versions[offset:])
+	} else if _, err = b.versionrootfile.ReadAt(buf[:], int64((version-1)*8)); err != nil {
+		return 0, 0, err
+	}
+	return binary.LittleEndian.Uint32(buf[0:]), binary.LittleEndian.Uint32(buf[4:]), nil
+}
+
+func (b *diskBackend) HighestVersion() (uint64, error) {
+	fstat, err := b.versionrootfile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fstat.Size()/8) + uint64(len(b.pending_versions)/8), nil
+}
+
+// header is kept in graviton_header file
+func (b *diskBackend) ReadHeader() ([]byte, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(b.dir, header_file))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return buf, err
+}
+
+func (b *diskBackend) WriteHeader(buf []byte) error {
+	return write_file_synced(filepath.Join(b.dir, header_file), buf)
+}
+
+// Close closes all files, pending version records must have been flushed by the store
+func (b *diskBackend) Close() error {
+	for _, f := range b.files {
+		f.handle.Close()
+	}
+	return b.versionrootfile.Close()
+}
+
+// fsync all directories from the file upto the base directory, so as newly created entries survive a crash
+func (b *diskBackend) syncdirs(filename string) error {
+	for dir := filepath.Dir(filename); ; dir = filepath.Dir(dir) {
+		if err := syncdir(dir); err != nil {
+			return err
+		}
+		if len(dir) <= len(b.dir) || dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+func syncdir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,82 @@
+package graviton
+
+import "io"
+import "fmt"
+import "encoding/binary"
+
+// memoryBackend keeps data chunks and version records in memory, it always starts empty and everything is lost on
+// close. It is used by NewMemStore.
+type memoryBackend struct {
+	chunks    [][]byte
+	chunksize uint32 // a new chunk is started once a write does not fit, MAX_FILE_SIZE same as disk stores
+	versions  []byte // each version is 8 bytes and stores the chunk index and position
+	header    []byte
+}
+
+func new_memory_backend() *memoryBackend {
+	return &memoryBackend{chunks: [][]byte{{0}}, chunksize: MAX_FILE_SIZE} // write a byte so as mark 0,0 as invalid
+}
+
+func (b *memoryBackend) Write(buf []byte) (uint32, uint32, error) {
+	if len(b.chunks) == 0 {
+		return 0, 0, fmt.Errorf("probable store is closed")
+	}
+	findex := uint32(len(b.chunks) - 1)
+	size := uint32(len(b.chunks[findex]))
+	if size+uint32(len(buf)) > b.chunksize || size+uint32(len(buf)) < size {
+		b.chunks = append(b.chunks, []byte{})
+		findex, size = findex+1, 0
+	}
+	b.chunks[findex] = append(b.chunks[findex], buf...)
+	return findex, size, nil
+}
+
+func (b *memoryBackend) ReadAt(findex, fpos uint32, buf []byte) (int, error) {
+	if findex >= uint32(len(b.chunks)) {
+		return 0, fmt.Errorf("findex not available")
+	}
+	chunk := b.chunks[findex]
+	if fpos < uint32(len(chunk)) {
+		return copy(buf, chunk[fpos:]), nil
+	} else if fpos == uint32(len(chunk)) {
+		return 0, io.EOF
+	}
+	return 0, fmt.Errorf("out of range")
+}
+
+// versions are 1 based
+func (b *memoryBackend) WriteVersionData(version uint64, findex, fpos uint32) error {
+	for uint64(len(b.versions)) < version*8 {
+		b.versions = append(b.versions, []byte{0, 0, 0, 0, 0, 0, 0, 0}...)
+	}
+	binary.LittleEndian.PutUint32(b.versions[(version-1)*8:], findex)
+	binary.LittleEndian.PutUint32(b.versions[(version-1)*8+4:], fpos)
+	return nil
+}
+
+// versions are 1 based
+func (b *memoryBackend) ReadVersionData(version uint64) (findex uint32, fpos uint32, err error) {
+	if uint64(len(b.versions)) < version*8 {
+		return 0, 0, fmt.Errorf("invalid version %d %d", version, len(b.versions))
+	}
+	return binary.LittleEndian.Uint32(b.versions[(version-1)*8:]), binary.LittleEndian.Uint32(b.versions[(version-1)*8+4:]), nil
+}
+
+func (b *memoryBackend) HighestVersion() (uint64, error) {
+	return uint64(len(b.versions) / 8), nil
+}
+
+func (b *memoryBackend) ReadHeader() ([]byte, error) {
+	return b.header, nil
+}
+
+func (b *memoryBackend) WriteHeader(buf []byte) error {
+	b.header = append([]byte{}, buf...)
+	return nil
+}
+
+// Close drops all data
+func (b *memoryBackend) Close() error {
+	b.chunks, b.versions, b.header = nil, nil, nil
+	return nil
+}
@@ -0,0 +1,263 @@
+package graviton
+
+import "io"
+import "os"
+import "fmt"
+import "sync"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/binary"
+
+import "golang.org/x/xerrors"
+
+// default chunk size for object stores, objects are immutable so chunks are kept much smaller than MAX_FILE_SIZE
+const OBJECT_CHUNK_SIZE = 64 * 1024 * 1024
+
+// ObjectStore is the minimal api required from an S3 style bucket.
+// objects are written exactly once and never modified afterwards.
+type ObjectStore interface {
+	Put(name string, data []byte) error                          // upload a complete object
+	GetRange(name string, offset int64, buf []byte) (int, error) // ranged read, io.EOF if object ends
+	Exists(name string) (bool, error)                            // check whether object exists
+	Delete(name string) error                                    // delete an object
+}
+
+// DirObjectStore is a local filesystem directory acting as an S3 compatible bucket,
+// it can be used for testing or to stage data offline
+type DirObjectStore struct {
+	dir string
+}
+
+// create a directory based object store, directory is created if it does not exist
+func NewDirObjectStore(dir string) (*DirObjectStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DirObjectStore{dir: dir}, nil
+}
+
+func (d *DirObjectStore) path(name string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(name))
+}
+
+// objects are first written to a temporary file and then renamed so as partial objects are never visible
+func (d *DirObjectStore) Put(name string, data []byte) error {
+	fname := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return err
+	}
+	tmpname := fname + ".tmp"
+	if err := ioutil.WriteFile(tmpname, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpname, fname)
+}
+
+func (d *DirObjectStore) GetRange(name string, offset int64, buf []byte) (int, error) {
+	f, err := os.Open(d.path(name))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(buf, offset)
+}
+
+func (d *DirObjectStore) Exists(name string) (bool, error) {
+	_, err := os.Stat(d.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *DirObjectStore) Delete(name string) error {
+	err := os.Remove(d.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ObjectBackend keeps each sealed data chunk as an immutable object, named as d/c/b/a.dfs same as disk store.
+// The chunk currently being appended is buffered in a local directory until it is sealed and uploaded.
+// version records are small and mutable and thus are kept locally in version_root.bin
+// store header is kept in the bucket as reserved object graviton_header, it is written once when store is created
+type ObjectBackend struct {
+	objects   ObjectStore
+	localdir  string
+	chunksize uint32
+
+	findex   uint32   // currently open chunk
+	open     *os.File // open chunk is buffered locally
+	opensize uint32
+
+	versionrootfile *os.File
+
+	sync.RWMutex
+}
+
+// create an object store backend, chunksize 0 uses OBJECT_CHUNK_SIZE
+// localdir is used to buffer open chunk and to keep version records
+func NewObjectBackend(objects ObjectStore, localdir string, chunksize uint32) (*ObjectBackend, error) {
+	if chunksize == 0 {
+		chunksize = OBJECT_CHUNK_SIZE
+	}
+	if chunksize > MAX_FILE_SIZE {
+		return nil, fmt.Errorf("chunk size %d is more than allowed %d", chunksize, MAX_FILE_SIZE)
+	}
+	if err := os.MkdirAll(localdir, 0700); err != nil {
+		return nil, fmt.Errorf("direction creation err %s  dirpath %s \n", err, localdir)
+	}
+
+	b := &ObjectBackend{objects: objects, localdir: localdir, chunksize: chunksize}
+
+	var err error
+	if b.versionrootfile, err = os.OpenFile(filepath.Join(localdir, "version_root.bin"), os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return nil, err
+	}
+
+	// find the first chunk which has not been sealed yet
+	for b.findex = 0; ; b.findex++ {
+		sealed, err := objects.Exists(chunk_name(b.findex))
+		if err != nil {
+			b.versionrootfile.Close()
+			return nil, err
+		}
+		if !sealed {
+			break
+		}
+		os.Remove(b.localname(b.findex)) // we might have crashed after upload but before local cleanup
+	}
+
+	if err = b.openchunk(b.findex); err != nil {
+		b.versionrootfile.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *ObjectBackend) localname(n uint32) string {
+	return filepath.Join(b.localdir, filepath.FromSlash(chunk_name(n)))
+}
+
+// open or create local buffer for a chunk
+func (b *ObjectBackend) openchunk(n uint32) (err error) {
+	fname := b.localname(n)
+	if err = os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return err
+	}
+	if b.open, err = os.OpenFile(fname, os.O_CREATE|os.O_RDWR, 0600); err != nil {
+		return xerrors.Errorf("%w:  index %d, filename %s", err, n, fname)
+	}
+	finfo, err := b.open.Stat()
+	if err != nil {
+		return err
+	}
+	b.findex, b.opensize = n, uint32(finfo.Size())
+	if n == 0 && b.opensize == 0 { // write a byte so as mark 0,0 as invalid
+		if _, err = b.open.WriteAt([]byte{0x0}, 0); err != nil {
+			return err
+		}
+		b.opensize = 1
+	}
+	return nil
+}
+
+// seal the current chunk, upload it and start a new one
+func (b *ObjectBackend) seal() error {
+	data := make([]byte, b.opensize)
+	if _, err := b.open.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if err := b.objects.Put(chunk_name(b.findex), data); err != nil {
+		return err
+	}
+	b.open.Close()
+	os.Remove(b.localname(b.findex))
+	return b.openchunk(b.findex + 1)
+}
+
+func (b *ObjectBackend) Write(buf []byte) (uint32, uint32, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.opensize > 0 && (b.opensize+uint32(len(buf)) > b.chunksize || b.opensize+uint32(len(buf)) < b.opensize) {
+		if err := b.seal(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	pos := b.opensize
+	done, err := b.open.WriteAt(buf, int64(pos))
+	b.opensize += uint32(done)
+	return b.findex, pos, err
+}
+
+func (b *ObjectBackend) ReadAt(findex, fpos uint32, buf []byte) (int, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if findex == b.findex {
+		return b.open.ReadAt(buf, int64(fpos))
+	} else if findex > b.findex {
+		return 0, fmt.Errorf("findex not available")
+	}
+	return b.objects.GetRange(chunk_name(findex), int64(fpos), buf)
+}
+
+// versions are 1 based
+func (b *ObjectBackend) WriteVersionData(version uint64, findex, fpos uint32) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint32(buf[0:], findex)
+	binary.LittleEndian.PutUint32(buf[4:], fpos)
+	_, err := b.versionrootfile.WriteAt(buf[:], int64((version-1)*8))
+	return err
+}
+
+// versions are 1 based
+func (b *ObjectBackend) ReadVersionData(version uint64) (findex uint32, fpos uint32, err error) {
+	var buf [8]byte
+	if _, err = b.versionrootfile.ReadAt(buf[:], int64((version-1)*8)); err != nil {
+		return 0, 0, err
+	}
+	return binary.LittleEndian.Uint32(buf[0:]), binary.LittleEndian.Uint32(buf[4:]), nil
+}
+
+func (b *ObjectBackend) HighestVersion() (uint64, error) {
+	fstat, err := b.versionrootfile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fstat.Size() / 8), nil
+}
+
+// header is small, so it is read completely
+func (b *ObjectBackend) ReadHeader() ([]byte, error) {
+	exists, err := b.objects.Exists(header_file)
+	if err != nil || !exists {
+		return nil, err
+	}
+	var header []byte
+	buf := make([]byte, 512)
+	for {
+		n, err := b.objects.GetRange(header_file, int64(len(header)), buf)
+		header = append(header, buf[:n]...)
+		if err == io.EOF || (err == nil && n < len(buf)) {
+			return header, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (b *ObjectBackend) WriteHeader(buf []byte) error {
+	return b.objects.Put(header_file, buf)
+}
+
+// Close closes local files, the open chunk stays buffered locally and will be used on next open
+func (b *ObjectBackend) Close() error {
+	b.Lock()
+	defer b.Unlock()
+	b.open.Close()
+	return b.versionrootfile.Close()
+}
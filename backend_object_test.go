@@ -0,0 +1,119 @@
+package graviton
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestObjectBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_objects")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	objects, err := NewDirObjectStore(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+
+	_, err = NewObjectBackend(objects, filepath.Join(dir, "local"), MAX_FILE_SIZE+1)
+	require.Error(t, err)
+
+	backend, err := NewObjectBackend(objects, filepath.Join(dir, "local"), 4096)
+	require.NoError(t, err)
+	store, err := NewStoreWithBackend(backend)
+	require.NoError(t, err)
+
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	var keys, values [][]byte
+	for i := 0; i < 200; i++ {
+		key := make([]byte, 20)
+		value := make([]byte, 100)
+		rand.Read(key)
+		rand.Read(value)
+		require.NoError(t, tree.Put(key, value))
+		keys = append(keys, key)
+		values = append(values, value)
+		if i%10 == 0 {
+			require.NoError(t, tree.Commit())
+		}
+	}
+	require.NoError(t, tree.Commit())
+	roothash := tree.hashSkipError()
+
+	require.True(t, backend.findex > 2) // several chunks must have been sealed
+	for i := uint32(0); i < backend.findex; i++ {
+		exists, err := objects.Exists(chunk_name(i))
+		require.NoError(t, err)
+		require.True(t, exists)
+		_, err = os.Stat(backend.localname(i))
+		require.True(t, os.IsNotExist(err)) // sealed chunks are not kept locally
+	}
+	exists, err := objects.Exists(chunk_name(backend.findex))
+	require.NoError(t, err)
+	require.False(t, exists) // open chunk has not been uploaded
+
+	store.Close()
+
+	// reopen and verify all data
+	backend, err = NewObjectBackend(objects, filepath.Join(dir, "local"), 4096)
+	require.NoError(t, err)
+	store, err = NewStoreWithBackend(backend)
+	require.NoError(t, err)
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	require.Equal(t, roothash, tree.hashSkipError())
+	for i, key := range keys {
+		value, err := tree.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, values[i], value)
+	}
+
+	require.NoError(t, objects.Delete(chunk_name(0)))
+	require.NoError(t, objects.Delete(chunk_name(0))) // deleting missing object is not an error
+	store.Close()
+
+	// header is kept in the bucket and checked on open
+	exists, err = objects.Exists(header_file)
+	require.NoError(t, err)
+	require.True(t, exists)
+	buf, err := backend.ReadHeader()
+	require.NoError(t, err)
+	hdr, err := parse_header(header_file, buf)
+	require.NoError(t, err)
+	require.Equal(t, DefaultHash.Name, hdr.hash)
+
+	backend, err = NewObjectBackend(objects, filepath.Join(dir, "local"), 4096)
+	require.NoError(t, err)
+	_, err = NewStoreWithBackend(backend, StoreOptions{Hash: HASH_SHA256})
+	require.True(t, xerrors.Is(err, ErrHashMismatch))
+	backend.Close()
+
+	// a fresh bucket records the requested hash
+	objects, err = NewDirObjectStore(filepath.Join(dir, "bucket_sha256"))
+	require.NoError(t, err)
+	backend, err = NewObjectBackend(objects, filepath.Join(dir, "local_sha256"), 4096)
+	require.NoError(t, err)
+	store, err = NewStoreWithBackend(backend, StoreOptions{Hash: HASH_SHA256})
+	require.NoError(t, err)
+	require.Equal(t, HASH_SHA256, store.hash.Name)
+	store.Close()
+
+	backend, err = NewObjectBackend(objects, filepath.Join(dir, "local_sha256"), 4096)
+	require.NoError(t, err)
+	_, err = NewStoreWithBackend(backend, StoreOptions{Hash: HASH_BLAKE2S})
+	require.True(t, xerrors.Is(err, ErrHashMismatch))
+	store, err = NewStoreWithBackend(backend)
+	require.NoError(t, err)
+	require.Equal(t, HASH_SHA256, store.hash.Name)
+	store.Close()
+}
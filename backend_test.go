@@ -0,0 +1,163 @@
+package graviton
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// a minimal backend which keeps everything in slices, used to test the Backend plumbing
+type testbackend struct {
+	chunks   [][]byte
+	versions []byte
+	header   []byte
+	closed   bool
+}
+
+func (b *testbackend) Write(buf []byte) (uint32, uint32, error) {
+	if len(b.chunks) == 0 {
+		b.chunks = append(b.chunks, []byte{0}) // mark 0,0 as invalid
+	}
+	findex := uint32(len(b.chunks) - 1)
+	if len(b.chunks[findex])+len(buf) > 4096 { // use small chunks so as rollover is tested
+		b.chunks = append(b.chunks, nil)
+		findex++
+	}
+	fpos := uint32(len(b.chunks[findex]))
+	b.chunks[findex] = append(b.chunks[findex], buf...)
+	return findex, fpos, nil
+}
+
+func (b *testbackend) ReadAt(findex, fpos uint32, buf []byte) (int, error) {
+	if int(findex) >= len(b.chunks) || int(fpos) > len(b.chunks[findex]) {
+		return 0, fmt.Errorf("out of range")
+	}
+	c := copy(buf, b.chunks[findex][fpos:])
+	if c < len(buf) {
+		return c, io.EOF
+	}
+	return c, nil
+}
+
+func (b *testbackend) WriteVersionData(version uint64, findex, fpos uint32) error {
+	for uint64(len(b.versions)) < version*8 {
+		b.versions = append(b.versions, 0)
+	}
+	binary.LittleEndian.PutUint32(b.versions[(version-1)*8:], findex)
+	binary.LittleEndian.PutUint32(b.versions[(version-1)*8+4:], fpos)
+	return nil
+}
+
+func (b *testbackend) ReadVersionData(version uint64) (uint32, uint32, error) {
+	if version == 0 || uint64(len(b.versions)) < version*8 {
+		return 0, 0, fmt.Errorf("invalid version %d", version)
+	}
+	return binary.LittleEndian.Uint32(b.versions[(version-1)*8:]), binary.LittleEndian.Uint32(b.versions[(version-1)*8+4:]), nil
+}
+
+func (b *testbackend) HighestVersion() (uint64, error) {
+	return uint64(len(b.versions) / 8), nil
+}
+
+func (b *testbackend) ReadHeader() ([]byte, error) {
+	return b.header, nil
+}
+
+func (b *testbackend) WriteHeader(buf []byte) error {
+	b.header = buf
+	return nil
+}
+
+func (b *testbackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestBackendStore(t *testing.T) {
+	_, err := NewStoreWithBackend(nil)
+	require.Error(t, err)
+
+	backend := &testbackend{}
+	store, err := NewStoreWithBackend(backend)
+	require.NoError(t, err)
+
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	var keys, values [][]byte
+	var roothashes [][HASHSIZE]byte
+	for i := 0; i < 100; i++ {
+		key := make([]byte, 20)
+		value := make([]byte, 10)
+		rand.Read(key)
+		rand.Read(value)
+		require.NoError(t, tree.Put(key, value))
+		keys = append(keys, key)
+		values = append(values, value)
+		require.NoError(t, tree.Commit())
+		roothashes = append(roothashes, tree.hashSkipError())
+	}
+	require.True(t, len(backend.chunks) > 1)
+
+	store.Close()
+	require.True(t, backend.closed)
+
+	// reopen the store over same backend and verify everything
+	store, err = NewStoreWithBackend(backend)
+	require.NoError(t, err)
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	for i, key := range keys {
+		value, err := tree.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, values[i], value)
+	}
+
+	for i := uint64(1); i <= tree.GetVersion(); i++ {
+		vtree, err := gv.GetTreeWithVersion("root", i)
+		require.NoError(t, err)
+		require.Equal(t, roothashes[i-1], vtree.hashSkipError())
+	}
+
+	_, err = store.LoadSnapshot(1000)
+	require.Error(t, err)
+	_, _, err = store.ReadVersionData(1000)
+	require.Error(t, err)
+
+	// header is recorded through the backend and checked on open
+	hdr, err := parse_header("store", backend.header)
+	require.NoError(t, err)
+	require.Equal(t, storeHeader{format: STORE_FORMAT_VERSION, hash: DefaultHash.Name, max_file_size: MAX_FILE_SIZE}, hdr)
+	_, err = NewStoreWithBackend(backend, StoreOptions{Hash: HASH_SHA256})
+	require.True(t, xerrors.Is(err, ErrHashMismatch))
+
+	backend.header = storeHeader{format: STORE_FORMAT_VERSION + 1, hash: DefaultHash.Name, max_file_size: MAX_FILE_SIZE}.serialize()
+	_, err = NewStoreWithBackend(backend)
+	require.True(t, xerrors.Is(err, ErrUnsupportedFormat))
+
+	backend.header = []byte("garbage")
+	_, err = NewStoreWithBackend(backend)
+	require.Error(t, err)
+
+	// backends written before headers were recorded get the header of requested hash
+	backend.header = nil
+	store, err = NewStoreWithBackend(backend)
+	require.NoError(t, err)
+	hdr, err = parse_header("store", backend.header)
+	require.NoError(t, err)
+	require.Equal(t, DefaultHash.Name, hdr.hash)
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	require.Equal(t, roothashes[len(roothashes)-1], tree.hashSkipError())
+}
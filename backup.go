@@ -0,0 +1,746 @@
+package graviton
+
+import "io"
+import "os"
+import "fmt"
+import "math"
+import "hash"
+import "bytes"
+import "sort"
+import "bufio"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/binary"
+
+import "golang.org/x/xerrors"
+
+// Backups are a stream of all nodes reachable from a snapshot. Each node retains its original position (findex, fpos)
+// so as a restored store is physically identical for all reachable data, and thus snapshot versions, version roots
+// and tree roots are same as the source. Nodes are written children first, so that every node can be verified against
+// the hashes recorded in its parent while restoring.
+//
+// Neither side keeps a set of all nodes. Since data is append only, the nodes written by a commit of a tree lie after the
+// previous root of that tree, and everything before it is shared with older versions. So roots of a tree are written
+// in the order of their positions, and only nodes after the previous root of the tree are written. While reading,
+// children which are not in the stream are read back from the store and verified.
+//
+//	header:  magic, format version, kind, hash function name, snapshot version, base version, base findex, base fpos
+//	records: type, findex, fpos, length, raw node bytes
+//	         version record: type, version, findex, fpos
+//	trailer: end record, hash of version root, checksum of everything before checksum
+//
+// Checksum always uses the default hash function, nodes are hashed with the hash function of the store.
+const backup_magic = "GRAVITONBACKUP"
+const backup_format_version = 1
+
+const (
+	backup_full byte = iota + 1
+	backup_incremental
+)
+
+const (
+	record_end byte = iota
+	record_leaf
+	record_inner
+	record_root // inner node at bit 0, ie a tree root or a version root
+	record_version
+)
+
+var ErrBackupCorruption = xerrors.New("backup is corrupted")
+
+type backupWriter struct {
+	store *Store
+	w     *bufio.Writer
+	h     hash.Hash
+	low   uint64 // nodes at or before this position are already written or present at destination
+	buf   bytes.Buffer
+}
+
+func (bw *backupWriter) Write(buf []byte) (int, error) {
+	bw.h.Write(buf)
+	return bw.w.Write(buf)
+}
+
+func (bw *backupWriter) writeUvarint(v uint64) error {
+	var tbuf [binary.MaxVarintLen64]byte
+	_, err := bw.Write(tbuf[:binary.PutUvarint(tbuf[:], v)])
+	return err
+}
+
+func (bw *backupWriter) record(rtype byte, findex, fpos uint32, raw []byte) (err error) {
+	if _, err = bw.Write([]byte{rtype}); err == nil {
+		if err = bw.writeUvarint(uint64(findex)); err == nil {
+			if err = bw.writeUvarint(uint64(fpos)); err == nil {
+				if err = bw.writeUvarint(uint64(len(raw))); err == nil {
+					_, err = bw.Write(raw)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Backup streams all data reachable from a snapshot, 0 means most recent snapshot
+// backups can be taken while the store is being committed to since committed data never changes
+func (s *Store) Backup(w io.Writer, version uint64) error {
+	return s.backup(w, version, 0)
+}
+
+// BackupIncremental streams only the data written after snapshot version from, which is reachable from snapshot
+// version to. The result can be applied using ApplyBackup onto a store which already holds version from, such as a
+// store restored from a full backup of version from.
+// Since data is append only, everything written before version from is expected to be present at the destination,
+// this is verified while applying.
+func (s *Store) BackupIncremental(w io.Writer, from, to uint64) error {
+	if from == 0 {
+		return fmt.Errorf("base version cannot be 0")
+	}
+	return s.backup(w, to, from)
+}
+
+// base version is 0 for full backups, otherwise nodes written before the base version root are skipped
+func (s *Store) backup(w io.Writer, version, base_version uint64) (err error) {
+	ss, err := s.LoadSnapshot(version)
+	if err != nil {
+		return err
+	}
+	if ss.findex == 0 && ss.fpos == 0 {
+		return fmt.Errorf("empty store cannot be backed up")
+	}
+
+	bw := &backupWriter{store: s, w: bufio.NewWriter(w), h: DefaultHash.hasher()}
+
+	kind := backup_full
+	var base_findex, base_fpos uint32
+	if base_version != 0 {
+		kind = backup_incremental
+		if base_findex, base_fpos, err = s.ReadVersionData(base_version); err != nil {
+			return err
+		}
+		if base_findex == 0 && base_fpos == 0 {
+			return xerrors.Errorf("%w: version %d", ErrVersionNotStored, base_version)
+		}
+		if position_key(base_findex, base_fpos) >= position_key(ss.findex, ss.fpos) {
+			return fmt.Errorf("base version %d must be older than version %d", base_version, ss.version)
+		}
+	}
+	base := position_key(base_findex, base_fpos) // data is append only, full backups have no base
+
+	if _, err = bw.Write([]byte(backup_magic)); err != nil {
+		return
+	}
+	if _, err = bw.Write([]byte{backup_format_version, kind}); err != nil {
+		return
+	}
+	if err = bw.writeUvarint(uint64(len(s.hash.Name))); err != nil {
+		return
+	}
+	if _, err = bw.Write([]byte(s.hash.Name)); err != nil {
+		return
+	}
+	for _, v := range []uint64{ss.version, base_version, uint64(base_findex), uint64(base_fpos)} { // full backups have no base
+		if err = bw.writeUvarint(v); err != nil {
+			return
+		}
+	}
+
+	// all trees pointed to by the version root, oldest first
+	var roots []uint64
+	cursor := (&Tree{store: s, root: ss.vroot}).Cursor()
+	_, v, err := cursor.First()
+	for ; err == nil; _, v, err = cursor.Next() {
+		if !is_version_value(v) {
+			if findex, fpos := decode(v); position_key(findex, fpos) > base {
+				roots = append(roots, position_key(findex, fpos))
+			}
+		}
+	}
+	if err != ErrNoMoreKeys {
+		return
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i] < roots[j] })
+
+	previous := map[string]uint64{} // position of previous root of every tree
+	for i, pos := range roots {
+		if i > 0 && roots[i-1] == pos { // same root is referenced by version, hash and tags
+			continue
+		}
+		var name string
+		var root *inner
+		if name, root, err = s.loadrootusingpos(uint32(pos>>32), uint32(pos)); err != nil {
+			return
+		}
+		if bw.low = base; previous[name] > base {
+			bw.low = previous[name]
+		}
+		if err = bw.emit(root, true); err != nil {
+			return
+		}
+		previous[name] = pos
+	}
+
+	bw.low = base // a version root shares nothing with tree roots
+	if err = bw.emit(ss.vroot, true); err != nil {
+		return
+	}
+	if _, err = bw.Write([]byte{record_version}); err != nil {
+		return
+	}
+	for _, v := range []uint64{ss.version, uint64(ss.findex), uint64(ss.fpos)} {
+		if err = bw.writeUvarint(v); err != nil {
+			return
+		}
+	}
+
+	var vroothash []byte
+	if vroothash, err = ss.vroot.Hash(s); err != nil {
+		return
+	}
+	if _, err = bw.Write([]byte{record_end}); err != nil {
+		return
+	}
+	if _, err = bw.Write(vroothash); err != nil {
+		return
+	}
+	if _, err = bw.w.Write(bw.h.Sum(nil)); err != nil { // checksum itself is not part of checksum
+		return
+	}
+	return bw.w.Flush()
+}
+
+// write a node after all its children, nodes shared with older roots have already been written
+func (bw *backupWriter) emit(n node, root bool) (err error) {
+	findex, fpos := n.Position()
+	if position_key(findex, fpos) <= bw.low {
+		switch v := n.(type) { // hash is known from parent
+		case *inner:
+			v.loaded_partial = false
+		case *leaf:
+			v.loaded_partial = false
+		}
+		return nil
+	}
+
+	if err = n.load_partial(bw.store); err != nil {
+		return
+	}
+
+	bw.buf.Reset()
+	switch v := n.(type) {
+	case *leaf:
+		v.MarshalTo(&bw.buf)
+		err = bw.record(record_leaf, findex, fpos, bw.buf.Bytes())
+		v.key, v.value = nil, nil // only hash is needed henceforth
+
+	case *inner:
+		if v.left != nil {
+			if err = bw.emit(v.left, false); err != nil {
+				return
+			}
+			v.left_findex, v.left_fpos = v.left.Position()
+		}
+		if v.right != nil {
+			if err = bw.emit(v.right, false); err != nil {
+				return
+			}
+			v.right_findex, v.right_fpos = v.right.Position()
+		}
+
+		var buf [384]byte
+		var done int
+		if done, err = v.MarshalTo(bw.store, buf[:], string(v.bucket_name)); err != nil {
+			return
+		}
+		rtype := record_inner
+		if root {
+			rtype = record_root
+		}
+		if err = bw.record(rtype, findex, fpos, buf[:done]); err != nil {
+			return
+		}
+		if !root { // release memory, hash has already been loaded from parent
+			v.left, v.right = nil, nil
+		}
+
+	default:
+		return fmt.Errorf("unknown node type")
+	}
+	return
+}
+
+// RestoreStore imports a full backup into a new disk store at dir. Every node is re-hashed and verified
+// against its parent while importing and the complete stream is verified against the checksum.
+// dir must be empty or not exist, on failure everything written to it is removed.
+func RestoreStore(r io.Reader, dir string) (*Store, error) {
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("restore directory %s is not empty", dir)
+	}
+
+	br := newBackupReader(r)
+	hdr, err := br.readHeader(backup_full)
+	if err != nil {
+		return nil, err
+	}
+	_, staterr := os.Stat(dir)
+	store, err := NewDiskStore(dir, StoreOptions{Hash: hdr.hash.Name})
+	if err == nil {
+		if err = store.restore(br, hdr); err == nil {
+			return store, nil
+		}
+		store.Close()
+	}
+
+	// nothing must be left behind, a half restored store is of no use
+	if os.IsNotExist(staterr) {
+		os.RemoveAll(dir)
+	} else if entries, rerr := ioutil.ReadDir(dir); rerr == nil {
+		for _, entry := range entries {
+			os.RemoveAll(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return nil, err
+}
+
+// VerifyBackup checks a full backup by streaming it, every node is re-hashed and verified against its parent and the
+// complete stream is verified against the checksum. Nothing is written to disk. Contents of nodes shared between
+// trees and membership of tree roots in the version root can only be verified by reading back restored data, which
+// RestoreStore does.
+func VerifyBackup(r io.Reader) error {
+	br := newBackupReader(r)
+	hdr, err := br.readHeader(backup_full)
+	if err != nil {
+		return err
+	}
+	rs := &restorer{store: &Store{hash: hdr.hash, format: STORE_FORMAT_VERSION}, verify: true}
+	_, _, _, err = rs.read(br, hdr.version)
+	return err
+}
+
+type backupReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func newBackupReader(r io.Reader) *backupReader {
+	return &backupReader{r: bufio.NewReader(r), h: DefaultHash.hasher()}
+}
+
+type backupHeader struct {
+	kind                   byte
+	hash                   *HashFunction
+	version, base_version  uint64
+	base_findex, base_fpos uint32
+}
+
+// parse and validate header, backup must be of expected kind
+func (br *backupReader) readHeader(kind byte) (hdr backupHeader, err error) {
+	var header [len(backup_magic) + 2]byte
+	if err = br.read(header[:]); err != nil {
+		return
+	}
+	if string(header[:len(backup_magic)]) != backup_magic {
+		return hdr, xerrors.Errorf("%w: not a backup", ErrBackupCorruption)
+	}
+	format := header[len(backup_magic)]
+	if format != backup_format_version {
+		return hdr, fmt.Errorf("unsupported backup format version %d", format)
+	}
+	if hdr.kind = header[len(backup_magic)+1]; hdr.kind != kind {
+		return hdr, fmt.Errorf("backup kind %d cannot be used here, expected %d", hdr.kind, kind)
+	}
+
+	var namelen uint64
+	if namelen, err = br.readUvarint(TREE_NAME_LIMIT); err != nil {
+		return
+	}
+	name := make([]byte, namelen)
+	if err = br.read(name); err != nil {
+		return
+	}
+	if hdr.hash, err = GetHash(string(name)); err != nil {
+		return
+	}
+
+	var params [4]uint64
+	for i := range params {
+		if params[i], err = br.readUvarint(math.MaxUint64); err != nil {
+			return
+		}
+	}
+	hdr.version, hdr.base_version = params[0], params[1]
+	if hdr.version == 0 || params[2] > math.MaxUint32 || params[3] > math.MaxUint32 || (kind == backup_full && hdr.base_version != 0) {
+		return hdr, xerrors.Errorf("%w: invalid header", ErrBackupCorruption)
+	}
+	hdr.base_findex, hdr.base_fpos = uint32(params[2]), uint32(params[3])
+	return
+}
+
+func (br *backupReader) ReadByte() (byte, error) {
+	b, err := br.r.ReadByte()
+	if err == nil {
+		br.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (br *backupReader) read(buf []byte) error {
+	if _, err := io.ReadFull(br.r, buf); err != nil {
+		return xerrors.Errorf("%w: %s", ErrBackupCorruption, err)
+	}
+	br.h.Write(buf)
+	return nil
+}
+
+func (br *backupReader) readUvarint(limit uint64) (uint64, error) {
+	v, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, xerrors.Errorf("%w: %s", ErrBackupCorruption, err)
+	}
+	if v > limit {
+		return 0, xerrors.Errorf("%w: value %d is more than limit %d", ErrBackupCorruption, v, limit)
+	}
+	return v, nil
+}
+
+// ApplyBackup applies an incremental backup onto a disk store which holds its base version and no data after it.
+// all new nodes are verified while importing, and nodes referenced from older data are verified against the store.
+func (s *Store) ApplyBackup(r io.Reader) error {
+	if s.disk == nil {
+		return fmt.Errorf("backups can only be applied to disk stores")
+	}
+	br := newBackupReader(r)
+	hdr, err := br.readHeader(backup_incremental)
+	if err != nil {
+		return err
+	}
+	if hdr.hash.Name != s.hash.Name {
+		return fmt.Errorf("backup uses hash function %s, store uses %s", hdr.hash.Name, s.hash.Name)
+	}
+
+	s.commitsync.Lock()
+	defer s.commitsync.Unlock()
+	return s.restore(br, hdr)
+}
+
+type restorer struct {
+	store       *Store
+	verify      bool // only verify the stream, nothing is written to store
+	incremental bool
+	end         uint64      // committed data ends here, restored data cannot overwrite it
+	fresh       []freshNode // nodes of the stream which are yet to be referenced by their parent
+	written     []span      // sorted, merged ranges written by the stream, a few per commit as nodes of a commit are contiguous
+	roots       [][]byte    // hashes of all tree roots and version root
+	last        uint64      // position of last root, version root is the last one
+	lasthash    []byte
+}
+
+type freshNode struct {
+	pos  uint64
+	hash []byte
+}
+
+type span struct {
+	start, end uint64
+}
+
+// a node has at most one unreferenced sibling pending on every level
+const max_fresh_nodes = HASHSIZE*8 + 2
+
+// import a backup, whose header has already been read, into store. incremental backups are applied over existing data
+func (s *Store) restore(br *backupReader, hdr backupHeader) (err error) {
+	rs := &restorer{store: s, incremental: hdr.kind == backup_incremental}
+	rs.end = position_key(s.disk.findex, s.disk.files[s.disk.findex].size)
+	version, base_version := hdr.version, hdr.base_version
+
+	if rs.incremental { // base must match exactly and nothing must have been committed after it
+		findex, fpos, err := s.ReadVersionData(base_version)
+		if err != nil || findex != hdr.base_findex || fpos != hdr.base_fpos || (findex == 0 && fpos == 0) {
+			return fmt.Errorf("store does not contain base version %d of incremental backup", base_version)
+		}
+		_, highest, _, _, err := s.findhighestsnapshotinram()
+		if err != nil {
+			return err
+		}
+		if highest != base_version {
+			return fmt.Errorf("store has versions after base version %d, highest version %d", base_version, highest)
+		}
+		rs.end = position_key(findex, fpos) + 1 // anything after base version root is uncommitted garbage
+	}
+
+	vroot_findex, vroot_fpos, vroothash, err := rs.read(br, version)
+	if err != nil {
+		return err
+	}
+
+	// every tree root must be committed in version root, version record is written only after all checks pass
+	_, vroot, err := s.loadrootusingpos(vroot_findex, vroot_fpos)
+	if err != nil {
+		return err
+	}
+	for _, roothash := range rs.roots {
+		if bytes.Equal(roothash, vroothash[:]) {
+			continue
+		}
+		if _, err = vroot.Get(s, s.hash.sum(roothash)); err != nil {
+			return xerrors.Errorf("%w: tree root %x is not part of snapshot", ErrBackupCorruption, roothash)
+		}
+	}
+
+	if err = s.flush(); err != nil { // data must be durable before version record points to it
+		return
+	}
+	if err = s.writeVersionData(version, vroot_findex, vroot_fpos); err != nil {
+		return
+	}
+	if err = s.flush(); err != nil {
+		return
+	}
+	return s.disk.versionrootfile.Sync()
+}
+
+// read all records and the trailer, returns position and hash of version root
+func (rs *restorer) read(br *backupReader, version uint64) (vroot_findex, vroot_fpos uint32, vroothash [HASHSIZE]byte, err error) {
+	var vroot_found bool
+	for {
+		var rtype byte
+		if rtype, err = br.ReadByte(); err != nil {
+			err = xerrors.Errorf("%w: %s", ErrBackupCorruption, err)
+			return
+		}
+		if rtype == record_end {
+			break
+		}
+		if vroot_found {
+			err = xerrors.Errorf("%w: data after version record", ErrBackupCorruption)
+			return
+		}
+
+		var findex, fpos, length uint64
+		if rtype == record_version {
+			if length, err = br.readUvarint(math.MaxUint64); err == nil && length != version {
+				err = xerrors.Errorf("%w: version record mismatch", ErrBackupCorruption)
+			}
+		}
+		if err == nil {
+			findex, err = br.readUvarint(math.MaxUint32)
+		}
+		if err == nil {
+			fpos, err = br.readUvarint(math.MaxUint32)
+		}
+		if err != nil {
+			return
+		}
+		if rtype == record_version {
+			vroot_findex, vroot_fpos, vroot_found = uint32(findex), uint32(fpos), true
+			continue
+		}
+
+		limit := uint64(MINBLOCK)
+		if rtype == record_leaf {
+			limit = MAX_VALUE_SIZE + 2*MINBLOCK
+		}
+		if length, err = br.readUvarint(limit); err != nil {
+			return
+		}
+		raw := make([]byte, length)
+		if err = br.read(raw); err != nil {
+			return
+		}
+		if err = rs.restore_node(rtype, uint32(findex), uint32(fpos), raw); err != nil {
+			return
+		}
+	}
+
+	if err = br.read(vroothash[:]); err != nil {
+		return
+	}
+	var checksum [HASHSIZE]byte
+	expected := br.h.Sum(nil)
+	if _, err = io.ReadFull(br.r, checksum[:]); err != nil || !bytes.Equal(checksum[:], expected) {
+		err = xerrors.Errorf("%w: checksum mismatch", ErrBackupCorruption)
+		return
+	}
+	if !vroot_found {
+		err = xerrors.Errorf("%w: version record missing", ErrBackupCorruption)
+		return
+	}
+	if rs.last != position_key(vroot_findex, vroot_fpos) || !bytes.Equal(rs.lasthash, vroothash[:]) {
+		err = xerrors.Errorf("%w: version root hash mismatch", ErrBackupCorruption)
+	}
+	return
+}
+
+// verify a node against the hashes of its children and write it at its position
+func (rs *restorer) restore_node(rtype byte, findex, fpos uint32, raw []byte) (err error) {
+	pos := position_key(findex, fpos)
+	if pos == 0 {
+		return xerrors.Errorf("%w: invalid position", ErrBackupCorruption)
+	}
+	if pos < rs.end { // data can never be overwritten
+		return xerrors.Errorf("%w: node at findex %d fpos %d overlaps existing data", ErrBackupCorruption, findex, fpos)
+	}
+
+	var nodehash []byte
+	switch rtype {
+	case record_leaf:
+		var l leaf
+		if err = l.Unmarshal(rs.store, raw); err != nil {
+			return xerrors.Errorf("%w: %s", ErrBackupCorruption, err)
+		}
+		nodehash = append([]byte{}, l.hash[:]...)
+
+	case record_inner, record_root:
+		bit := uint8(1)
+		if rtype == record_root {
+			bit = 0
+		}
+		in := newInner(bit)
+		if err = in.Unmarshal(raw); err != nil {
+			return xerrors.Errorf("%w: %s", ErrBackupCorruption, err)
+		}
+		for _, child := range []node{in.right, in.left} { // right child was written last, so it is on top
+			if child != nil {
+				if err = rs.verify_child(child, pos); err != nil {
+					return
+				}
+			}
+		}
+		if in.left != nil {
+			in.left_findex, in.left_fpos = in.left.Position()
+		}
+		if in.right != nil {
+			in.right_findex, in.right_fpos = in.right.Position()
+		}
+
+		var buf [384]byte // node must be in canonical form
+		done, err := in.MarshalTo(rs.store, buf[:], string(in.bucket_name))
+		if err != nil || !bytes.Equal(buf[:done], raw) {
+			return xerrors.Errorf("%w: inner node at findex %d fpos %d is not canonical", ErrBackupCorruption, findex, fpos)
+		}
+		if nodehash, err = in.Hash(rs.store); err != nil {
+			return err
+		}
+
+	default:
+		return xerrors.Errorf("%w: unknown record type %d", ErrBackupCorruption, rtype)
+	}
+
+	if err = rs.mark(pos, pos+uint64(len(raw))); err != nil {
+		return
+	}
+	if !rs.verify {
+		if err = rs.store.writeAt(findex, fpos, raw); err != nil {
+			return
+		}
+	}
+
+	if rtype == record_root { // a root completes a tree, everything before it must have been referenced
+		if len(rs.fresh) != 0 {
+			return xerrors.Errorf("%w: unreferenced node at findex %d fpos %d", ErrBackupCorruption, uint32(rs.fresh[0].pos>>32), uint32(rs.fresh[0].pos))
+		}
+		rs.roots = append(rs.roots, nodehash)
+		rs.last, rs.lasthash = pos, nodehash
+		return nil
+	}
+	if len(rs.fresh) >= max_fresh_nodes {
+		return xerrors.Errorf("%w: too many unreferenced nodes", ErrBackupCorruption)
+	}
+	rs.fresh = append(rs.fresh, freshNode{pos: pos, hash: nodehash})
+	return nil
+}
+
+// record a written range, ranges of nodes can never overlap
+func (rs *restorer) mark(start, end uint64) error {
+	i := sort.Search(len(rs.written), func(i int) bool { return rs.written[i].end >= start })
+	if (i < len(rs.written) && rs.written[i].start < end && start < rs.written[i].end) ||
+		(i+1 < len(rs.written) && rs.written[i+1].start < end) {
+		return xerrors.Errorf("%w: node at findex %d fpos %d overlaps another node", ErrBackupCorruption, uint32(start>>32), uint32(start))
+	}
+
+	left := i < len(rs.written) && rs.written[i].end == start
+	if left {
+		rs.written[i].end = end
+	} else {
+		rs.written = append(rs.written, span{})
+		copy(rs.written[i+1:], rs.written[i:])
+		rs.written[i] = span{start: start, end: end}
+	}
+	if i+1 < len(rs.written) && rs.written[i+1].start == end { // merge with next range
+		rs.written[i].end = rs.written[i+1].end
+		rs.written = append(rs.written[:i+1], rs.written[i+2:]...)
+	}
+	return nil
+}
+
+// whether a node starting at pos was written by the stream
+func (rs *restorer) written_at(pos uint64) bool {
+	i := sort.Search(len(rs.written), func(i int) bool { return rs.written[i].end > pos })
+	return i < len(rs.written) && rs.written[i].start <= pos
+}
+
+// children are either the last unreferenced nodes of the stream, or shared with older trees and thus restored
+// earlier or for incremental backups, present in store
+func (rs *restorer) verify_child(child node, parent uint64) error {
+	findex, fpos := child.Position()
+	pos := position_key(findex, fpos)
+
+	var expected []byte
+	switch v := child.(type) {
+	case *inner:
+		expected = v.hash
+	case *leaf:
+		expected = v.hash_check[:]
+	}
+
+	if n := len(rs.fresh); n > 0 && rs.fresh[n-1].pos == pos {
+		if !bytes.Equal(rs.fresh[n-1].hash, expected) {
+			return xerrors.Errorf("%w: hash mismatch for node at findex %d fpos %d", ErrBackupCorruption, findex, fpos)
+		}
+		rs.fresh = rs.fresh[:n-1]
+	} else if pos >= parent || !(rs.written_at(pos) || (rs.incremental && pos < rs.end)) { // children are written first
+		return xerrors.Errorf("%w: node at findex %d fpos %d is missing", ErrBackupCorruption, findex, fpos)
+	} else if !rs.verify {
+		if err := rs.verify_existing(child, expected); err != nil {
+			return err
+		}
+	}
+
+	switch v := child.(type) { // hash is verified, no need to load it
+	case *inner:
+		v.loaded_partial = false
+	case *leaf:
+		v.loaded_partial = false
+	}
+	return nil
+}
+
+// verify a node which is referenced by the backup but stored earlier in the store
+func (rs *restorer) verify_existing(child node, expected []byte) error {
+	findex, fpos := child.Position()
+	switch child.(type) {
+	case *inner:
+		in := newInner(1)
+		in.findex, in.fpos = findex, fpos
+		if err := in.loadinnerfromstore(rs.store); err != nil {
+			return xerrors.Errorf("%w: node at findex %d fpos %d is missing in store: %s", ErrBackupCorruption, findex, fpos, err)
+		}
+		for _, grandchild := range []node{in.left, in.right} { // hashes recorded in node are sufficient
+			switch v := grandchild.(type) {
+			case *inner:
+				v.loaded_partial = false
+			case *leaf:
+				v.loaded_partial = false
+			}
+		}
+		if h, err := in.Hash(rs.store); err != nil || !bytes.Equal(h, expected) {
+			return xerrors.Errorf("%w: node at findex %d fpos %d does not match store", ErrBackupCorruption, findex, fpos)
+		}
+	case *leaf:
+		l := &leaf{findex: findex, fpos: fpos, loaded_partial: true}
+		copy(l.hash_check[:], expected)
+		if err := l.loadfullleaffromstore(rs.store); err != nil {
+			return xerrors.Errorf("%w: node at findex %d fpos %d does not match store: %s", ErrBackupCorruption, findex, fpos, err)
+		}
+	}
+	return nil
+}
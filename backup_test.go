@@ -0,0 +1,255 @@
+package graviton
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// setup a store with 2 trees and some history
+func setupBackupStore(t *testing.T, store *Store, commits int) (hashes []map[string][HASHSIZE]byte) {
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree1, err := gv.GetTree("tree1")
+	require.NoError(t, err)
+	tree2, err := gv.GetTree("tree2")
+	require.NoError(t, err)
+
+	for i := 0; i < commits; i++ {
+		for j := 0; j < 20; j++ {
+			require.NoError(t, tree1.Put([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d-%d", i, j))))
+		}
+		require.NoError(t, tree2.Put([]byte(fmt.Sprintf("key%d", i)), make([]byte, 1000)))
+		require.NoError(t, tree2.Delete([]byte(fmt.Sprintf("key%d", i/2))))
+		tree1.Tags = []string{fmt.Sprintf("tag%d", i)}
+		_, err := Commit(tree1, tree2)
+		require.NoError(t, err)
+		hashes = append(hashes, map[string][HASHSIZE]byte{"tree1": tree1.hashSkipError(), "tree2": tree2.hashSkipError()})
+	}
+	return
+}
+
+// compare all trees and all their versions in a snapshot
+func compareSnapshots(t *testing.T, a, b *Snapshot) {
+	require.Equal(t, a.GetVersion(), b.GetVersion())
+	ahash, err := a.vroot.Hash(a.store)
+	require.NoError(t, err)
+	bhash, err := b.vroot.Hash(b.store)
+	require.NoError(t, err)
+	require.Equal(t, ahash, bhash)
+
+	for _, treename := range []string{"tree1", "tree2"} {
+		highest, err := a.GetTreeHighestVersion(treename)
+		require.NoError(t, err)
+		for version := uint64(1); version <= highest; version++ {
+			atree, err := a.GetTreeWithVersion(treename, version)
+			require.NoError(t, err)
+			btree, err := b.GetTreeWithVersion(treename, version)
+			require.NoError(t, err)
+			require.Equal(t, atree.hashSkipError(), btree.hashSkipError())
+
+			ac, bc := atree.Cursor(), btree.Cursor()
+			ak, av, aerr := ac.First()
+			bk, bv, berr := bc.First()
+			for ; aerr == nil && berr == nil; ak, av, aerr = ac.Next() {
+				require.Equal(t, ak, bk)
+				require.Equal(t, av, bv)
+				bk, bv, berr = bc.Next()
+			}
+			require.Equal(t, aerr, berr)
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(filepath.Join(dir, "source"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.Error(t, store.Backup(&buf, 0)) // empty store
+
+	hashes := setupBackupStore(t, store, 10)
+
+	for _, version := range []uint64{0, 4} {
+		buf.Reset()
+		require.NoError(t, store.Backup(&buf, version))
+		require.NoError(t, VerifyBackup(bytes.NewReader(buf.Bytes())))
+
+		restoredir := filepath.Join(dir, fmt.Sprintf("restored%d", version))
+		restored, err := RestoreStore(bytes.NewReader(buf.Bytes()), restoredir)
+		require.NoError(t, err)
+
+		ss, err := store.LoadSnapshot(version)
+		require.NoError(t, err)
+		rss, err := restored.LoadSnapshot(0)
+		require.NoError(t, err)
+		compareSnapshots(t, ss, rss)
+
+		tree, err := rss.GetTreeWithTag(fmt.Sprintf("tag%d", rss.GetVersion()-1))
+		require.NoError(t, err)
+		require.Equal(t, hashes[rss.GetVersion()-1]["tree1"], tree.hashSkipError())
+
+		_, err = restored.LoadSnapshot(1) // versions before backed up snapshot are not present
+		require.True(t, xerrors.Is(err, ErrVersionNotStored))
+
+		// restored store can be committed to and reopened
+		tree, err = rss.GetTree("tree2")
+		require.NoError(t, err)
+		require.NoError(t, tree.Put([]byte("newkey"), []byte("newvalue")))
+		require.NoError(t, tree.Commit())
+		restored.Close()
+
+		restored, err = NewDiskStore(restoredir)
+		require.NoError(t, err)
+		require.False(t, restored.RecoveryReport().Repaired())
+		rss, err = restored.LoadSnapshot(0)
+		require.NoError(t, err)
+		tree, err = rss.GetTree("tree2")
+		require.NoError(t, err)
+		value, err := tree.Get([]byte("newkey"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("newvalue"), value)
+		restored.Close()
+
+		// cannot restore over existing data
+		_, err = RestoreStore(bytes.NewReader(buf.Bytes()), restoredir)
+		require.Error(t, err)
+	}
+
+	// failed restores leave nothing behind, whether directory existed or not
+	truncated := buf.Bytes()[:buf.Len()-1]
+	faileddir := filepath.Join(dir, "failed")
+	_, err = RestoreStore(bytes.NewReader(truncated), faileddir)
+	require.Error(t, err)
+	_, err = os.Stat(faileddir)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, os.Mkdir(faileddir, 0700))
+	_, err = RestoreStore(bytes.NewReader(truncated), faileddir)
+	require.Error(t, err)
+	entries, err := ioutil.ReadDir(faileddir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// memory stores can also be backed up
+	memstore, err := NewMemStore()
+	require.NoError(t, err)
+	setupBackupStore(t, memstore, 3)
+	buf.Reset()
+	require.NoError(t, memstore.Backup(&buf, 0))
+	require.NoError(t, VerifyBackup(bytes.NewReader(buf.Bytes())))
+}
+
+func TestBackupCorruption(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	setupBackupStore(t, store, 3)
+
+	var buf bytes.Buffer
+	require.NoError(t, store.Backup(&buf, 0))
+	backup := buf.Bytes()
+
+	// every truncation must be detected
+	for _, length := range []int{0, 5, len(backup_magic) + 3, len(backup) / 2, len(backup) - 1} {
+		err := VerifyBackup(bytes.NewReader(backup[:length]))
+		require.Error(t, err)
+	}
+
+	// every single byte corruption must be detected
+	for i := 0; i < len(backup); i += 1 + len(backup)/200 {
+		corrupted := append([]byte{}, backup...)
+		corrupted[i] ^= 0x40
+		require.Error(t, VerifyBackup(bytes.NewReader(corrupted)), "corruption at %d not detected", i)
+	}
+
+	corrupted := append([]byte{}, backup...)
+	corrupted[len(backup)-1] ^= 1
+	require.True(t, xerrors.Is(VerifyBackup(bytes.NewReader(corrupted)), ErrBackupCorruption))
+}
+
+func TestBackupIncremental(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(filepath.Join(dir, "source"))
+	require.NoError(t, err)
+	setupBackupStore(t, store, 10)
+
+	var full, delta1, delta2 bytes.Buffer
+	require.NoError(t, store.Backup(&full, 4))
+	require.Error(t, store.BackupIncremental(&delta1, 0, 7))
+	require.Error(t, store.BackupIncremental(&delta1, 7, 4)) // base must be older
+	require.NoError(t, store.BackupIncremental(&delta1, 4, 7))
+	require.NoError(t, store.BackupIncremental(&delta2, 7, 10))
+	require.True(t, delta2.Len() < full.Len())
+
+	restored, err := RestoreStore(bytes.NewReader(full.Bytes()), filepath.Join(dir, "restored"))
+	require.NoError(t, err)
+
+	require.Error(t, restored.ApplyBackup(bytes.NewReader(full.Bytes())))   // full backups cannot be applied
+	require.Error(t, restored.ApplyBackup(bytes.NewReader(delta2.Bytes()))) // base is not present
+
+	corrupted := append([]byte{}, delta1.Bytes()...)
+	corrupted[len(corrupted)/2] ^= 0x40
+	require.Error(t, restored.ApplyBackup(bytes.NewReader(corrupted)))
+	rss, err := restored.LoadSnapshot(0) // failed apply writes no version record
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), rss.GetVersion())
+
+	require.NoError(t, restored.ApplyBackup(bytes.NewReader(delta1.Bytes())))
+	require.Error(t, restored.ApplyBackup(bytes.NewReader(delta1.Bytes()))) // cannot be applied twice
+	require.NoError(t, restored.ApplyBackup(bytes.NewReader(delta2.Bytes())))
+
+	for _, version := range []uint64{7, 10} {
+		ss, err := store.LoadSnapshot(version)
+		require.NoError(t, err)
+		rss, err := restored.LoadSnapshot(version)
+		require.NoError(t, err)
+		compareSnapshots(t, ss, rss)
+	}
+	restored.Close()
+
+	// store which has been committed to after base version cannot accept increments
+	restored, err = RestoreStore(bytes.NewReader(full.Bytes()), filepath.Join(dir, "restored_modified"))
+	require.NoError(t, err)
+	gv, err := restored.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("tree1")
+	require.NoError(t, err)
+	require.NoError(t, tree.Put([]byte("key"), []byte("value")))
+	require.NoError(t, tree.Commit())
+	require.Error(t, restored.ApplyBackup(bytes.NewReader(delta1.Bytes())))
+
+	memstore, err := NewMemStore()
+	require.NoError(t, err)
+	require.Error(t, memstore.ApplyBackup(bytes.NewReader(delta1.Bytes())))
+}
+
+func TestBackupWrittenRanges(t *testing.T) {
+	rs := &restorer{}
+	require.NoError(t, rs.mark(10, 20))
+	require.NoError(t, rs.mark(30, 40))
+	require.NoError(t, rs.mark(20, 25)) // merged with previous
+	require.NoError(t, rs.mark(25, 30)) // fills the gap
+	require.Equal(t, []span{{10, 40}}, rs.written)
+	require.NoError(t, rs.mark(0, 5))
+	require.NoError(t, rs.mark(50, 60))
+	require.Equal(t, []span{{0, 5}, {10, 40}, {50, 60}}, rs.written)
+
+	for _, overlap := range []span{{0, 5}, {4, 6}, {8, 11}, {39, 45}, {45, 55}, {9, 61}} {
+		require.True(t, xerrors.Is(rs.mark(overlap.start, overlap.end), ErrBackupCorruption), "%v", overlap)
+	}
+	require.True(t, rs.written_at(10) && rs.written_at(39) && rs.written_at(0))
+	require.False(t, rs.written_at(5) || rs.written_at(40) || rs.written_at(60))
+}
@@ -0,0 +1,223 @@
+package graviton
+
+import "bytes"
+import "encoding/binary"
+
+import "golang.org/x/xerrors"
+
+import "github.com/deroproject/graviton/verifier"
+
+// ChangeSet is a serializable diff of a tree, it converts a tree with root BaseRoot into a tree with root ResultRoot.
+// Followers can apply changesets of every commit instead of copying full snapshots, roots are checked on both sides.
+type ChangeSet struct {
+	Tree       string // name of tree, only informational
+	BaseRoot   [HASHSIZE]byte
+	ResultRoot [HASHSIZE]byte
+	Ops        []TransitionOp // puts and deletes in hash order of keys
+}
+
+// NewChangeSet returns the changeset which converts base tree into head tree
+func NewChangeSet(base_tree, head_tree *Tree) (cs *ChangeSet, err error) {
+	cs = &ChangeSet{Tree: head_tree.treename}
+	if cs.BaseRoot, err = base_tree.Hash(); err != nil {
+		return nil, err
+	}
+	if cs.ResultRoot, err = head_tree.Hash(); err != nil {
+		return nil, err
+	}
+	it := NewDiffIterator(base_tree, head_tree)
+	for {
+		c, err := it.Next()
+		if err == ErrNoMoreKeys {
+			return cs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cs.add(c)
+	}
+}
+
+// keys and values are copied, since diffs only return them for the life of the trees
+func (cs *ChangeSet) add(c Change) {
+	op := TransitionOp{Key: append([]byte{}, c.Key...), Delete: c.Kind == Deleted}
+	if !op.Delete {
+		op.Value = append([]byte{}, c.NewValue...)
+	}
+	cs.Ops = append(cs.Ops, op)
+}
+
+// SnapshotChangeSets returns changesets of all trees which differ between snapshots a and b, see DiffSnapshots
+func SnapshotChangeSets(a, b *Snapshot) (changesets []*ChangeSet, err error) {
+	err = DiffSnapshots(a, b, func(c TreeChange) error {
+		if len(changesets) == 0 || changesets[len(changesets)-1].Tree != c.Tree {
+			cs := &ChangeSet{Tree: c.Tree}
+			base_tree, err := snapshot_tree(a, c.Tree)
+			if err == nil {
+				cs.BaseRoot, err = base_tree.Hash()
+			}
+			var head_tree *Tree
+			if err == nil {
+				head_tree, err = snapshot_tree(b, c.Tree)
+			}
+			if err == nil {
+				cs.ResultRoot, err = head_tree.Hash()
+			}
+			if err != nil {
+				return err
+			}
+			changesets = append(changesets, cs)
+		}
+		changesets[len(changesets)-1].add(c.Change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changesets, nil
+}
+
+// ApplyChangeSet applies a changeset to a tree, tree must have the base root of the changeset. If the resulting root
+// does not match, all changes done by the changeset are reverted, errors wrap ErrRootMismatch. Tree is not committed.
+func ApplyChangeSet(tree *Tree, cs *ChangeSet) (err error) {
+	root, err := tree.Hash()
+	if err != nil {
+		return err
+	}
+	if root != cs.BaseRoot {
+		return xerrors.Errorf("%w: tree root %x, changeset base root %x", ErrRootMismatch, root, cs.BaseRoot)
+	}
+
+	var undo []TransitionOp
+	for _, op := range cs.Ops {
+		var old []byte
+		if old, err = tree.Get(op.Key); err == nil {
+			undo = append(undo, TransitionOp{Key: op.Key, Value: old})
+		} else if xerrors.Is(err, ErrNotFound) {
+			undo = append(undo, TransitionOp{Key: op.Key, Delete: true})
+		} else {
+			break
+		}
+
+		if op.Delete {
+			err = tree.Delete(op.Key)
+		} else {
+			err = tree.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		if root, err = tree.Hash(); err == nil && root != cs.ResultRoot {
+			err = xerrors.Errorf("%w: resulting root %x, changeset result root %x", ErrRootMismatch, root, cs.ResultRoot)
+		}
+	}
+	if err != nil {
+		if uerr := revert(tree, undo); uerr != nil {
+			return xerrors.Errorf("%v, reverting changeset failed: %w", err, uerr)
+		}
+	}
+	return err
+}
+
+// undo operations in reverse order
+func revert(tree *Tree, undo []TransitionOp) (err error) {
+	for i := len(undo) - 1; i >= 0 && err == nil; i-- {
+		if undo[i].Delete {
+			if err = tree.Delete(undo[i].Key); xerrors.Is(err, ErrNotFound) {
+				err = nil
+			}
+		} else {
+			err = tree.Put(undo[i].Key, undo[i].Value)
+		}
+	}
+	return
+}
+
+// Serialize the changeset to a byte array
+//
+//	1 byte version
+//	varint length prefixed tree name
+//	32 byte(HASHSIZE) base root, 32 byte(HASHSIZE) result root
+//	varint number of operations
+//	for every operation, 1 byte delete flag, varint length prefixed key and if not delete varint length prefixed value
+func (cs *ChangeSet) Marshal() []byte {
+	var b bytes.Buffer
+	var buf [binary.MaxVarintLen64]byte
+	put_bytes := func(data []byte) {
+		b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))])
+		b.Write(data)
+	}
+
+	b.WriteByte(1) // version
+	put_bytes([]byte(cs.Tree))
+	b.Write(cs.BaseRoot[:])
+	b.Write(cs.ResultRoot[:])
+	b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(cs.Ops)))])
+	for _, op := range cs.Ops {
+		if op.Delete {
+			b.WriteByte(1)
+			put_bytes(op.Key)
+		} else {
+			b.WriteByte(0)
+			put_bytes(op.Key)
+			put_bytes(op.Value)
+		}
+	}
+	return b.Bytes()
+}
+
+// Unmarshal deserializes a changeset, errors wrap ErrBadChangeSet
+func (cs *ChangeSet) Unmarshal(buf []byte) (err error) {
+	*cs = ChangeSet{}
+	if err = cs.unmarshal(buf); err != nil {
+		*cs = ChangeSet{}
+		return xerrors.Errorf("%w: %v", ErrBadChangeSet, err)
+	}
+	return nil
+}
+
+func (cs *ChangeSet) unmarshal(buf []byte) (err error) {
+	if len(buf) < 1 || buf[0] != 1 {
+		return xerrors.Errorf("unknown version")
+	}
+	name, done, err := verifier.ReadBytes(buf, 1, TREE_NAME_LIMIT)
+	if err != nil {
+		return err
+	}
+	cs.Tree = string(name)
+	if len(buf)-done < 2*HASHSIZE {
+		return xerrors.Errorf("roots are truncated")
+	}
+	done += copy(cs.BaseRoot[:], buf[done:])
+	done += copy(cs.ResultRoot[:], buf[done:])
+
+	count, done, err := verifier.ReadUvarint(buf, done)
+	if err != nil {
+		return err
+	}
+	if count > uint64(len(buf)-done)/2 { // every operation needs atleast 2 bytes
+		return xerrors.Errorf("%d operations cannot fit in %d bytes", count, len(buf)-done)
+	}
+	cs.Ops = make([]TransitionOp, count)
+	for i := range cs.Ops {
+		op := &cs.Ops[i]
+		if done >= len(buf) || buf[done] > 1 {
+			return xerrors.Errorf("operation %d has invalid type", i)
+		}
+		op.Delete = buf[done] == 1
+		if op.Key, done, err = verifier.ReadBytes(buf, done+1, MAX_KEYSIZE); err != nil {
+			return err
+		}
+		if !op.Delete {
+			if op.Value, done, err = verifier.ReadBytes(buf, done, MAX_VALUE_SIZE); err != nil {
+				return err
+			}
+		}
+	}
+	if done != len(buf) {
+		return xerrors.Errorf("%d extra bytes", len(buf)-done)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package graviton
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestChangeSet(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, tree.Commit())
+	for i := 0; i < 500; i += 3 {
+		require.NoError(t, tree.Delete([]byte(fmt.Sprintf("key%d", i))))
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i+1)), []byte("changed")))
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("new%d", i)), nil))
+	}
+	require.NoError(t, tree.Commit())
+
+	gv1, err := store.LoadSnapshot(1)
+	require.NoError(t, err)
+	base_tree, err := gv1.GetTree("root")
+	require.NoError(t, err)
+	gv2, err := store.LoadSnapshot(2)
+	require.NoError(t, err)
+	head_tree, err := gv2.GetTree("root")
+	require.NoError(t, err)
+
+	cs, err := NewChangeSet(base_tree, head_tree)
+	require.NoError(t, err)
+	require.Equal(t, "root", cs.Tree)
+	require.Len(t, cs.Ops, 3*167)
+
+	var decoded ChangeSet
+	buf := cs.Marshal()
+	require.NoError(t, decoded.Unmarshal(buf))
+	require.Equal(t, buf, decoded.Marshal())
+	for i := 0; i < len(buf); i++ {
+		require.True(t, xerrors.Is(decoded.Unmarshal(buf[:i]), ErrBadChangeSet), "length %d", i)
+	}
+	require.True(t, xerrors.Is(decoded.Unmarshal(append(buf, 0)), ErrBadChangeSet))
+	require.NoError(t, decoded.Unmarshal(buf))
+
+	// follower store applies the changeset
+	follower, err := NewMemStore()
+	require.NoError(t, err)
+	fgv, err := follower.LoadSnapshot(0)
+	require.NoError(t, err)
+	ftree, err := fgv.GetTree("root")
+	require.NoError(t, err)
+	require.True(t, xerrors.Is(ApplyChangeSet(ftree, &decoded), ErrRootMismatch)) // base is different
+	for i := 0; i < 500; i++ {
+		require.NoError(t, ftree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, ApplyChangeSet(ftree, &decoded))
+	require.Equal(t, cs.ResultRoot, ftree.hashSkipError())
+	require.True(t, xerrors.Is(ApplyChangeSet(ftree, &decoded), ErrRootMismatch)) // already applied
+
+	// a changeset producing wrong result is reverted
+	reverse, err := NewChangeSet(head_tree, base_tree)
+	require.NoError(t, err)
+	reverse.Ops[0].Value = []byte("tampered")
+	reverse.Ops[0].Delete = false
+	require.True(t, xerrors.Is(ApplyChangeSet(ftree, reverse), ErrRootMismatch))
+	require.Equal(t, cs.ResultRoot, ftree.hashSkipError())
+
+	reverse, err = NewChangeSet(head_tree, base_tree)
+	require.NoError(t, err)
+	require.NoError(t, ApplyChangeSet(ftree, reverse))
+	require.Equal(t, cs.BaseRoot, ftree.hashSkipError())
+}
+
+func TestSnapshotChangeSets(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree1, err := gv.GetTree("tree1")
+	require.NoError(t, err)
+	tree2, err := gv.GetTree("tree2")
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, tree1.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+		require.NoError(t, tree2.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	_, err = Commit(tree1)
+	require.NoError(t, err)
+	base, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	require.NoError(t, tree1.Put([]byte("key0"), []byte("changed")))
+	require.NoError(t, tree1.Commit())
+	require.NoError(t, tree2.Commit()) // tree2 is created
+	head, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+
+	changesets, err := SnapshotChangeSets(base, head)
+	require.NoError(t, err)
+	require.Len(t, changesets, 2)
+
+	// replay changesets on a copy of base snapshot trees
+	for _, cs := range changesets {
+		tree, err := base.GetTree(cs.Tree)
+		require.NoError(t, err)
+		require.NoError(t, ApplyChangeSet(tree, cs))
+		expected, err := head.GetTree(cs.Tree)
+		require.NoError(t, err)
+		require.Equal(t, expected.hashSkipError(), tree.hashSkipError())
+		if cs.Tree == "tree1" {
+			require.Len(t, cs.Ops, 1)
+		} else {
+			require.Len(t, cs.Ops, 100)
+		}
+	}
+}
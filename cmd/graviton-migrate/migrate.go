@@ -0,0 +1,36 @@
+package main
+
+import "os"
+import "fmt"
+import "flag"
+import "log"
+import "github.com/deroproject/graviton"
+
+var output = flag.String("o", "", "write migrated store into this directory, leaving original untouched (default is to upgrade in place)")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-o newdir] storedir\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Upgrades a Graviton DB disk store to format version %d. The store must not be in use.\n", graviton.STORE_FORMAT_VERSION)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	format, err := graviton.MigrateStore(flag.Arg(0), *output)
+	if err != nil {
+		log.Fatalf("migration failed: %s", err)
+	}
+
+	switch {
+	case format == graviton.STORE_FORMAT_VERSION && *output == "":
+		log.Printf("store is already at format version %d", format)
+	case *output != "":
+		log.Printf("store migrated from format version %d to %d into %s", format, graviton.STORE_FORMAT_VERSION, *output)
+	default:
+		log.Printf("store migrated from format version %d to %d", format, graviton.STORE_FORMAT_VERSION)
+	}
+}
@@ -0,0 +1,301 @@
+package graviton
+
+import "os"
+import "fmt"
+import "sort"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/binary"
+
+import "golang.org/x/xerrors"
+
+// Compaction copies everything reachable from a set of snapshots into a fresh generation of data files and
+// discards the rest. Retained snapshots keep only the tree versions which were current in some retained snapshot,
+// entries (versions, root hashes, tags) pointing to any other tree version are dropped.
+// The most recent snapshot is always retained, version numbers are preserved and dropped versions return
+// ErrVersionNotStored.
+//
+// Compaction holds the commit lock for the whole copy, so commits block till it completes, readers may continue
+// using the old store. On success the store directory is switched to the new generation and a new store is
+// returned, the old store can still be read (open files stay valid till closed) but it can no longer be committed to.
+// If the directory switch fails, the old store is also retired and the error asks for a reopen, opening the store
+// completes the switch.
+func (s *Store) Compact(versions []uint64) (*Store, error) {
+	if s.disk == nil {
+		return nil, fmt.Errorf("compaction is only supported on disk stores")
+	}
+
+	s.commitsync.Lock()
+	defer s.commitsync.Unlock()
+
+	_, highest, _, _, err := s.findhighestsnapshotinram()
+	if err != nil {
+		return nil, err
+	}
+	if highest == 0 {
+		return nil, fmt.Errorf("nothing to compact")
+	}
+
+	keep := map[uint64]bool{highest: true}
+	for _, version := range versions {
+		if version < 1 || version > highest {
+			return nil, xerrors.Errorf("%w: version %d, highest version %d", ErrVersionNotStored, version, highest)
+		}
+		keep[version] = true
+	}
+
+	tmpdir := s.disk.dir + ".compact"
+	os.RemoveAll(tmpdir) // remains of an earlier failed compaction
+	err = s.compact_into(tmpdir, keep, highest)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(tmpdir, compaction_marker), nil, 0600)
+	}
+	if err == nil {
+		err = syncdir(tmpdir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, err
+	}
+
+	s.retired = true // directory may be half switched on errors, commits would be lost when switch is completed
+	if err = finish_compaction(s.disk.dir); err != nil {
+		return nil, xerrors.Errorf("switching to compacted generation failed, store must be reopened: %w", err)
+	}
+	return NewDiskStore(s.disk.dir, s.options)
+}
+
+var rename_dir = os.Rename // tests inject failures here
+
+// copy retained snapshots into a new store at dir, which is always written in current format
+func (s *Store) compact_into(dir string, keep map[uint64]bool, highest uint64) error {
+	dst, err := NewDiskStore(dir, StoreOptions{Sync: SyncCommit, Hash: s.hash.Name})
+	if err != nil {
+		return err
+	}
+	c := compactor{src: s, dst: dst, writer: &Tree{store: dst}, copied: map[uint64]uint64{}, counts: map[uint64]uint64{}}
+	if err = c.run(keep, highest); err == nil {
+		err = dst.flush()
+	}
+	dst.Close()
+	return err
+}
+
+// keep last n snapshots, n <= 0 keeps internal_MAX_VERSIONS_TO_KEEP snapshots
+func (s *Store) CompactKeepLast(n int) (*Store, error) {
+	if n <= 0 {
+		n = internal_MAX_VERSIONS_TO_KEEP
+	}
+	_, highest, _, _, err := s.findhighestsnapshotinram()
+	if err != nil {
+		return nil, err
+	}
+	var versions []uint64
+	for i := 0; i < n && highest > uint64(i); i++ {
+		versions = append(versions, highest-uint64(i))
+	}
+	return s.Compact(versions)
+}
+
+// marker file written once new generation is complete
+const compaction_marker = "compaction_complete"
+
+// switch the store directory to the newly compacted generation, this is also called while opening a store, so as
+// a crash midway through the switch is completed
+func finish_compaction(basepath string) error {
+	tmpdir, olddir := basepath+".compact", basepath+".old"
+
+	if _, err := os.Stat(filepath.Join(tmpdir, compaction_marker)); err != nil { // new generation is not complete
+		if _, err := os.Stat(basepath); os.IsNotExist(err) { // crashed while switching from an incomplete state, restore
+			if _, err := os.Stat(olddir); err == nil {
+				return rename_dir(olddir, basepath)
+			}
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(basepath); err == nil {
+		os.RemoveAll(olddir)
+		if err = rename_dir(basepath, olddir); err != nil {
+			return err
+		}
+	}
+	if err := rename_dir(tmpdir, basepath); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(basepath, compaction_marker))
+	if err := syncdir(filepath.Dir(basepath)); err != nil {
+		return err
+	}
+	return os.RemoveAll(olddir) // reclaim old generation
+}
+
+type compactor struct {
+	src, dst *Store
+	writer   *Tree             // used to serialize leaves to dst
+	copied   map[uint64]uint64 // old position to new position, shared subtrees are copied only once
+	counts   map[uint64]uint64 // leaf count of copied inner nodes, by new position
+}
+
+func position_key(findex, fpos uint32) uint64 {
+	return uint64(findex)<<32 | uint64(fpos)
+}
+
+func (c *compactor) run(keep map[uint64]bool, highest uint64) error {
+	var versions []uint64
+	for version := range keep {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	// pass 1, find all tree roots which are current in any of the retained snapshot
+	snapshots := map[uint64]*Snapshot{}
+	roots := map[uint64]bool{}
+	for _, version := range versions {
+		ss, err := c.src.LoadSnapshot(version)
+		if err != nil {
+			return err
+		}
+		snapshots[version] = ss
+
+		cursor := (&Tree{store: c.src, root: ss.vroot}).Cursor()
+		for k, v, err := cursor.First(); err == nil; k, v, err = cursor.Next() {
+			if _, ok := tree_version_entry(k, v); !ok {
+				continue
+			}
+			tree_version, _ := binary.Uvarint(v)
+			key := append(append([]byte{}, k...), make([]byte, binary.MaxVarintLen64)...)
+			key = key[:len(k)+binary.PutUvarint(key[len(k):], tree_version)]
+			position, err := ss.vroot.Get(c.src, c.src.hash.sum(key))
+			if err != nil {
+				return err
+			}
+			roots[position_key(decode(position))] = true
+		}
+	}
+
+	// pass 2, copy retained trees and write new version roots
+	for version := uint64(1); version <= highest; version++ {
+		var findex, fpos uint32
+		if ss, ok := snapshots[version]; ok {
+			var err error
+			if findex, fpos, err = c.copy_snapshot(ss, roots); err != nil {
+				return err
+			}
+		}
+		if err := c.dst.writeVersionData(version, findex, fpos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// highest version entries contain a single uvarint, while position entries contain findex, fpos
+func is_version_value(v []byte) bool {
+	_, size := binary.Uvarint(v)
+	return size > 0 && size == len(v)
+}
+
+// version root holds ":"+treename for highest version of every tree, returns tree name of such entries
+func tree_version_entry(k, v []byte) (string, bool) {
+	if len(k) < 1 || k[0] != ':' || !is_version_value(v) {
+		return "", false
+	}
+	treename := string(k[1:])
+	return treename, check_tree_name(treename) == nil
+}
+
+// rebuild the version root of a snapshot, pointing to copied trees
+func (c *compactor) copy_snapshot(ss *Snapshot, roots map[uint64]bool) (uint32, uint32, error) {
+	vroot := newInner(0)
+	vroot.version_current, vroot.version_previous = ss.vroot.version_current, ss.vroot.version_previous
+
+	cursor := (&Tree{store: c.src, root: ss.vroot}).Cursor()
+	k, v, err := cursor.First()
+	for ; err == nil; k, v, err = cursor.Next() {
+		value := v
+		if !is_version_value(v) { // value points to a tree root
+			findex, fpos := decode(v)
+			if !roots[position_key(findex, fpos)] {
+				continue // tree version is not retained
+			}
+			root := newInner(0)
+			root.findex, root.fpos, root.loaded_partial, root.dirty = findex, fpos, true, false
+			if findex, fpos, err = c.copy_node(root); err != nil {
+				return 0, 0, err
+			}
+			var valuearray [HASHSIZE]byte
+			value = valuearray[:encode(findex, fpos, valuearray[:])]
+		}
+		if err = vroot.Insert(c.dst, newLeaf(c.dst, c.dst.hash.sum(k), k, value)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err != ErrNoMoreKeys {
+		return 0, 0, err
+	}
+	return c.copy_node(vroot)
+}
+
+// copy a node and everything below it, dirty nodes are written as is, others are loaded from source store
+func (c *compactor) copy_node(n node) (findex, fpos uint32, err error) {
+	if !n.isDirty() {
+		if pos, ok := c.copied[position_key(n.Position())]; ok { // already copied, hash is known from parent
+			switch v := n.(type) {
+			case *inner:
+				v.loaded_partial = false
+				v.count, v.counted = c.counts[pos], true // source may not record counts
+			case *leaf:
+				v.loaded_partial = false
+			}
+			return uint32(pos >> 32), uint32(pos), nil
+		}
+		if err = n.load_partial(c.src); err != nil {
+			return
+		}
+	}
+	oldfindex, oldfpos := n.Position()
+
+	switch v := n.(type) {
+	case *leaf:
+		if findex, fpos, err = c.writer.commit_leaf(0, v); err != nil {
+			return
+		}
+		v.loaded_partial = false // only hash is needed henceforth
+
+	case *inner:
+		if v.left != nil {
+			if v.left_findex, v.left_fpos, err = c.copy_node(v.left); err != nil {
+				return
+			}
+		}
+		if v.right != nil {
+			if v.right_findex, v.right_fpos, err = c.copy_node(v.right); err != nil {
+				return
+			}
+		}
+
+		var buf [384]byte
+		var done int
+		if done, err = v.MarshalTo(c.dst, buf[:], string(v.bucket_name)); err != nil {
+			return
+		}
+		if findex, fpos, err = c.dst.write(buf[:done]); err != nil {
+			return
+		}
+		if _, err = v.Hash(c.dst); err != nil { // cache hash before releasing children
+			return
+		}
+		c.counts[position_key(findex, fpos)] = v.count // cached while marshalling
+		v.left, v.right = nil, nil
+		v.findex, v.fpos, v.dirty = findex, fpos, false
+
+	default:
+		return 0, 0, fmt.Errorf("unknown node type")
+	}
+
+	if oldfindex != 0 || oldfpos != 0 {
+		c.copied[position_key(oldfindex, oldfpos)] = position_key(findex, fpos)
+	}
+	return
+}
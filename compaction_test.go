@@ -0,0 +1,223 @@
+package graviton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func dirsize(t *testing.T, dir string) (size int64) {
+	require.NoError(t, filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return err
+	}))
+	return
+}
+
+func TestCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_compaction")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	memstore, _ := NewMemStore()
+	_, err = memstore.Compact(nil)
+	require.Error(t, err)
+
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	_, err = store.Compact(nil)
+	require.Error(t, err) // nothing to compact
+
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree1, err := gv.GetTree("tree1")
+	require.NoError(t, err)
+	tree2, err := gv.GetTree("tree2")
+	require.NoError(t, err)
+
+	hashes := map[uint64][2][HASHSIZE]byte{}
+	for i := 0; i < 30; i++ {
+		for j := 0; j < 50; j++ { // overwrite same keys, so as old versions become garbage
+			require.NoError(t, tree1.Put([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d-%d", i, j))))
+		}
+		require.NoError(t, tree2.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+		version, err := Commit(tree1, tree2)
+		require.NoError(t, err)
+		hashes[version] = [2][HASHSIZE]byte{tree1.hashSkipError(), tree2.hashSkipError()}
+	}
+	tree1.Tags = []string{"tagged"}
+	version, err := Commit(tree1)
+	require.NoError(t, err)
+	hashes[version] = [2][HASHSIZE]byte{tree1.hashSkipError(), tree2.hashSkipError()}
+
+	_, err = store.Compact([]uint64{1000})
+	require.True(t, xerrors.Is(err, ErrVersionNotStored))
+
+	before := dirsize(t, dir)
+	newstore, err := store.Compact([]uint64{10, 29})
+	require.NoError(t, err)
+	require.True(t, dirsize(t, dir) < before/2)
+
+	// old store is retired but still readable
+	require.Error(t, tree1.Commit())
+	value, err := tree1.Get([]byte("key0"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value29-0"), value)
+
+	for version := uint64(1); version <= 31; version++ {
+		gv, err := newstore.LoadSnapshot(version)
+		if version != 10 && version != 29 && version != 31 {
+			require.True(t, xerrors.Is(err, ErrVersionNotStored))
+			continue
+		}
+		require.NoError(t, err)
+
+		tree, err := gv.GetTree("tree1")
+		require.NoError(t, err)
+		require.Equal(t, hashes[version][0], tree.hashSkipError())
+		value, err := tree.Get([]byte("key7"))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value%d-7", min_uint64(version, 30)-1)), value)
+
+		tree, err = gv.GetTree("tree2")
+		require.NoError(t, err)
+		require.Equal(t, hashes[version][1], tree.hashSkipError())
+
+		roothash := hashes[version][0]
+		tree, err = gv.GetTreeWithRootHash(roothash[:])
+		require.NoError(t, err)
+		require.Equal(t, hashes[version][0], tree.hashSkipError())
+	}
+
+	gv, err = newstore.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTreeWithTag("tagged")
+	require.NoError(t, err)
+	require.Equal(t, hashes[31][0], tree.hashSkipError())
+	_, err = gv.GetTreeWithVersion("tree2", 29) // retained through snapshot 29
+	require.NoError(t, err)
+	_, err = gv.GetTreeWithVersion("tree2", 5) // dropped
+	require.Error(t, err)
+
+	// new store can be committed to
+	tree, err = gv.GetTree("tree1")
+	require.NoError(t, err)
+	require.NoError(t, tree.Put([]byte("newkey"), []byte("newvalue")))
+	version, err = Commit(tree)
+	require.NoError(t, err)
+	require.Equal(t, uint64(32), version)
+	newstore.Close()
+
+	newstore, err = NewDiskStore(dir)
+	require.NoError(t, err)
+	require.False(t, newstore.RecoveryReport().Repaired())
+	gv, err = newstore.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("tree1")
+	require.NoError(t, err)
+	value, err = tree.Get([]byte("newkey"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("newvalue"), value)
+}
+
+func min_uint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// a crash after new generation was complete but before it was switched, is completed on next open
+func TestCompactionInterrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_compaction")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+	base := filepath.Join(dir, "db")
+
+	store, err := NewDiskStore(base)
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	require.NoError(t, tree.Put([]byte("key"), []byte("value")))
+	require.NoError(t, tree.Commit())
+	store.Close()
+
+	require.NoError(t, os.Rename(base, base+".compact"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(base+".compact", compaction_marker), nil, 0600))
+
+	store, err = NewDiskStore(base)
+	require.NoError(t, err)
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	value, err := tree.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), value)
+	_, err = os.Stat(base + ".compact")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCompactionSwitchFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_compaction")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+	base := filepath.Join(dir, "db")
+
+	store, err := NewDiskStore(base)
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, tree.Put([]byte("key"), []byte(fmt.Sprintf("value%d", i))))
+		require.NoError(t, tree.Commit())
+	}
+
+	// old generation is moved away, but new generation cannot be moved in
+	injected := xerrors.New("injected rename failure")
+	renames := 0
+	rename_dir = func(from, to string) error {
+		if renames++; renames == 2 {
+			return injected
+		}
+		return os.Rename(from, to)
+	}
+	_, err = store.Compact(nil)
+	rename_dir = os.Rename
+	require.True(t, xerrors.Is(err, injected))
+	require.Contains(t, err.Error(), "reopened")
+
+	// commits to the old store would be lost once switch completes, so they fail
+	require.NoError(t, tree.Put([]byte("key"), []byte("lost")))
+	require.Error(t, tree.Commit())
+	store.Close()
+
+	// reopening completes the switch
+	store, err = NewDiskStore(base)
+	require.NoError(t, err)
+	_, err = os.Stat(base + ".compact")
+	require.True(t, os.IsNotExist(err))
+	_, err = store.LoadSnapshot(1)
+	require.True(t, xerrors.Is(err, ErrVersionNotStored))
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	value, err := tree.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value4"), value)
+	require.NoError(t, tree.Put([]byte("key"), []byte("value5")))
+	require.NoError(t, tree.Commit())
+	store.Close()
+}
@@ -2,6 +2,8 @@ package graviton
 
 import "errors"
 
+import "github.com/deroproject/graviton/verifier"
+
 const (
 	HASHSIZE_BYTES  = 32 // we currently are using blake hash which is 256 bits or 32 bytes
 	HASHSIZE        = HASHSIZE_BYTES
@@ -17,8 +19,16 @@ const internal_MAX_VERSIONS_TO_KEEP = 20 // this many recent versions will be ke
 const internal_VERSION_RECORD_SIZE = 24  // three uint64
 
 var (
-	ErrNotFound         = errors.New("leaf not found")
-	ErrVersionNotStored = errors.New("no such version")
-	ErrCorruption       = errors.New("Data Corruption")
-	ErrNoMoreKeys       = errors.New("No more keys exist")
+	ErrNotFound          = errors.New("leaf not found")
+	ErrVersionNotStored  = errors.New("no such version")
+	ErrCorruption        = errors.New("Data Corruption")
+	ErrNoMoreKeys        = errors.New("No more keys exist")
+	ErrHashMismatch      = errors.New("hash function mismatch")
+	ErrMigrationRequired = errors.New("store must be migrated to current format")
+	ErrUnsupportedFormat = errors.New("store format is not supported")
+	ErrProofVersion      = verifier.ErrProofVersion // proof errors are shared with the verifier package
+	ErrTruncatedProof    = verifier.ErrTruncatedProof
+	ErrMalformedProof    = verifier.ErrMalformedProof
+	ErrRootMismatch      = errors.New("root hash mismatch")
+	ErrBadChangeSet      = errors.New("changeset is malformed")
 )
@@ -0,0 +1,87 @@
+package graviton
+
+import "bytes"
+
+import "golang.org/x/xerrors"
+
+// Every inner node knows the number of leaves beneath it, committed nodes record the counts of their inner children.
+// So the size of any subtree is known without visiting it, and positions in hash order ( the order in which cursor
+// iterates ) can be found in a single descent.
+// Counts are not part of hashes, so proofs and root hashes are not affected by them.
+
+// Count returns the exact number of keys in the tree
+func (t *Tree) Count() (uint64, error) {
+	return t.root.Count(t.store)
+}
+
+// KeyAt returns the key,value at position n ( starting from 0 ) in hash order
+func (t *Tree) KeyAt(n uint64) (k, v []byte, err error) {
+	var cnode node = t.root
+	for {
+		switch node := cnode.(type) {
+		case nil:
+			return nil, nil, xerrors.Errorf("%w: position %d", ErrNoMoreKeys, n)
+		case *inner:
+			if err = node.load_partial(t.store); err != nil {
+				return
+			}
+			var lcount uint64
+			if lcount, err = node_count(t.store, node.left); err != nil {
+				return
+			}
+			if n < lcount {
+				cnode = node.left
+			} else {
+				n -= lcount
+				cnode = node.right
+			}
+		case *leaf:
+			if n != 0 {
+				return nil, nil, xerrors.Errorf("%w: position %d", ErrNoMoreKeys, n)
+			}
+			if err = node.load_partial(t.store); err != nil {
+				return
+			}
+			return node.key, node.value, nil
+		default:
+			return nil, nil, xerrors.Errorf("unknown node type")
+		}
+	}
+}
+
+// Rank returns the number of keys which come before key in hash order, key itself need not exist.
+// if key exists, KeyAt(Rank(key)) returns it
+func (t *Tree) Rank(key []byte) (rank uint64, err error) {
+	keyhash := t.store.hash.sum(key)
+	var cnode node = t.root
+	for {
+		switch node := cnode.(type) {
+		case nil:
+			return
+		case *inner:
+			if err = node.load_partial(t.store); err != nil {
+				return
+			}
+			if isBitSet(keyhash[:], uint(node.bit)) {
+				var lcount uint64
+				if lcount, err = node_count(t.store, node.left); err != nil {
+					return
+				}
+				rank += lcount
+				cnode = node.right
+			} else {
+				cnode = node.left
+			}
+		case *leaf:
+			if err = node.load_partial(t.store); err != nil {
+				return
+			}
+			if bytes.Compare(node.keyhash[:], keyhash[:]) < 0 {
+				rank++
+			}
+			return
+		default:
+			return 0, xerrors.Errorf("unknown node type")
+		}
+	}
+}
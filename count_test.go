@@ -0,0 +1,173 @@
+package graviton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// count keys by iterating
+func countKeys(t *testing.T, tree *Tree) (count int) {
+	c := tree.Cursor()
+	for _, _, err := c.First(); err == nil; _, _, err = c.Next() {
+		count++
+	}
+	return
+}
+
+func TestCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_count")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	count, err := tree.Count()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), count)
+
+	rand.Seed(11)
+	keys := map[int]bool{}
+	for i := 0; i < 2000; i++ {
+		k := rand.Intn(1500)
+		if rand.Intn(4) == 0 {
+			require.NoError(t, tree.Delete([]byte(fmt.Sprintf("key%d", k))))
+			delete(keys, k)
+		} else {
+			require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", k)), []byte("value")))
+			keys[k] = true
+		}
+		if i%300 == 0 {
+			require.NoError(t, tree.Commit())
+		}
+		if i%97 == 0 {
+			count, err = tree.Count()
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(keys)), count)
+		}
+	}
+	require.NoError(t, tree.Commit())
+	store.Close()
+
+	// count of a reopened tree is known from its root alone
+	store, err = NewDiskStore(dir)
+	require.NoError(t, err)
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	require.True(t, tree.root.counted)
+	count, err = tree.Count()
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(keys)), count)
+	require.Equal(t, len(keys), countKeys(t, tree))
+	require.Equal(t, int64(len(keys)), tree.KeyCountEstimate())
+
+	// older versions keep their counts
+	oldtree, err := gv.GetTreeWithVersion("root", 1)
+	require.NoError(t, err)
+	count, err = oldtree.Count()
+	require.NoError(t, err)
+	require.Equal(t, uint64(countKeys(t, oldtree)), count)
+}
+
+func TestRankSelect(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	_, _, err = tree.KeyAt(0)
+	require.True(t, xerrors.Is(err, ErrNoMoreKeys))
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, tree.Commit())
+	require.NoError(t, tree.Put([]byte("uncommitted"), []byte("value")))
+
+	c := tree.Cursor()
+	var position uint64
+	for k, v, err := c.First(); err == nil; k, v, err = c.Next() {
+		key, value, err := tree.KeyAt(position)
+		require.NoError(t, err)
+		require.Equal(t, k, key)
+		require.Equal(t, v, value)
+
+		rank, err := tree.Rank(k)
+		require.NoError(t, err)
+		require.Equal(t, position, rank)
+		position++
+	}
+	require.Equal(t, uint64(501), position)
+
+	_, _, err = tree.KeyAt(position)
+	require.True(t, xerrors.Is(err, ErrNoMoreKeys))
+
+	// rank of a missing key is its insertion position
+	rank, err := tree.Rank([]byte("missing"))
+	require.NoError(t, err)
+	require.NoError(t, tree.Put([]byte("missing"), []byte("value")))
+	key, _, err := tree.KeyAt(rank)
+	require.NoError(t, err)
+	require.Equal(t, []byte("missing"), key)
+}
+
+func TestCountLegacyNodes(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	legacy, err := gv.GetTree("legacy")
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, legacy.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+	}
+	write_counts = false // nodes as written by older releases
+	require.NoError(t, legacy.Commit())
+	write_counts = true
+
+	// nodes without counts are counted by loading them
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	legacy, err = gv.GetTree("legacy")
+	require.NoError(t, err)
+	require.False(t, legacy.root.counted)
+	count, err := legacy.Count()
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), count)
+
+	// new nodes carry counts
+	fresh, err := gv.GetTree("fresh")
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, fresh.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+	}
+	require.NoError(t, legacy.Put([]byte("newkey"), []byte("value")))
+	_, err = Commit(legacy, fresh)
+	require.NoError(t, err)
+
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	fresh, err = gv.GetTree("fresh")
+	require.NoError(t, err)
+	require.True(t, fresh.root.counted)
+	legacy, err = gv.GetTree("legacy")
+	require.NoError(t, err)
+	count, err = legacy.Count()
+	require.NoError(t, err)
+	require.Equal(t, uint64(101), count)
+	require.Equal(t, 101, countKeys(t, legacy))
+}
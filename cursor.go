@@ -1,6 +1,9 @@
 package graviton
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 //Cursor represents an iterator that can traverse over all key/value pairs in a tree in hash sorted order.
 //Cursors can be obtained from a tree and are valid as long as the tree is valid.
@@ -31,6 +34,65 @@ func (c *Cursor) Last() (k, v []byte, err error) {
 	return c.next_internal(node(c.tree.root), true)
 }
 
+// SeekKey moves the cursor to the key or if it does not exist, to the first key after it in hash order.
+// If no such key exists ErrNoMoreKeys is returned. Next and Prev continue from the returned key.
+func (c *Cursor) SeekKey(key []byte) (k, v []byte, err error) {
+	return c.Seek(c.tree.store.hash.sum(key))
+}
+
+// Seek moves the cursor to the first key whose hash is same or greater than keyhash. If no such key exists
+// ErrNoMoreKeys is returned. Next and Prev continue from the returned key, so iteration can be resumed from hash
+// of the last key returned.
+func (c *Cursor) Seek(keyhash [HASHSIZE]byte) (k, v []byte, err error) {
+	c.node_path, c.left = c.node_path[:0], c.left[:0]
+	loop_node := node(c.tree.root)
+	for {
+		switch node := loop_node.(type) {
+		case *inner:
+			if node.loaded_partial { // if node is loaded partially, load it fully now
+				if err = node.loadinnerfromstore(c.tree.store); err != nil {
+					return
+				}
+			}
+
+			if isBitSet(keyhash[:], uint(node.bit)) { // 1 is right
+				if node.right == nil { // all keys of this subtree are smaller, so continue after it
+					return c.Next()
+				}
+				c.node_path = append(c.node_path, node)
+				c.left = append(c.left, false)
+				loop_node = node.right
+			} else { // 0 is left
+				if node.left == nil { // all keys of this subtree are greater
+					if node.right == nil { // empty tree
+						err = ErrNoMoreKeys
+						return
+					}
+					c.node_path = append(c.node_path, node)
+					c.left = append(c.left, false)
+					return c.next_internal(node.right, false)
+				}
+				c.node_path = append(c.node_path, node)
+				c.left = append(c.left, true)
+				loop_node = node.left
+			}
+
+		case *leaf:
+			if node.loaded_partial { // if leaf is loaded partially, load it fully now
+				if err = node.loadfullleaffromstore(c.tree.store); err != nil {
+					return
+				}
+			}
+			if bytes.Compare(node.keyhash[:], keyhash[:]) >= 0 {
+				return node.key, node.value, nil
+			}
+			return c.Next()
+		default:
+			return k, v, fmt.Errorf("unknown node type, corruption")
+		}
+	}
+}
+
 // this function will descend and reach the next or previous value
 func (c *Cursor) next_internal(loop_node node, reverse bool) (k, v []byte, err error) {
 	for {
@@ -132,7 +132,7 @@ func TestCursor_error_case(t *testing.T) {
 	for i := 0; i < 50000; i++ {
 		key := fmt.Sprintf("%d", i)
 
-		keyhash := sum([]byte(key))
+		keyhash := DefaultHash.sum([]byte(key))
 
 		keyhash_string := fmt.Sprintf("%02b", keyhash[0]>>6)
 
@@ -216,3 +216,110 @@ func TestCursor_error_case(t *testing.T) {
 	require.Error(t, err)
 
 }
+
+func TestCursorSeek(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	cursor := tree.Cursor()
+	_, _, err = cursor.SeekKey([]byte("key"))
+	require.Equal(t, ErrNoMoreKeys, err) // empty tree
+
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+
+	// keys in hash order
+	var keys []string
+	c := tree.Cursor()
+	for k, _, err := c.First(); err == nil; k, _, err = c.Next() {
+		keys = append(keys, string(k))
+	}
+	require.Len(t, keys, 2000)
+
+	check := func(tree *Tree) {
+		cursor := tree.Cursor()
+		for i, key := range keys {
+			if i%7 != 0 {
+				continue
+			}
+			k, v, err := cursor.SeekKey([]byte(key))
+			require.NoError(t, err)
+			require.Equal(t, key, string(k))
+			require.Equal(t, "value"+key[3:], string(v))
+			if i+1 < len(keys) {
+				k, _, err = cursor.Next()
+				require.NoError(t, err)
+				require.Equal(t, keys[i+1], string(k))
+			}
+
+			_, _, err = cursor.SeekKey([]byte(key))
+			require.NoError(t, err)
+			k, _, err = cursor.Prev()
+			if i == 0 {
+				require.Equal(t, ErrNoMoreKeys, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, keys[i-1], string(k))
+			}
+		}
+
+		// missing keys land on the next key in hash order
+		for i := 0; i < 1000; i++ {
+			missing := []byte(fmt.Sprintf("missing%d", i))
+			rank, err := tree.Rank(missing)
+			require.NoError(t, err)
+			k, _, err := cursor.SeekKey(missing)
+			if rank == uint64(len(keys)) {
+				require.Equal(t, ErrNoMoreKeys, err)
+				continue
+			}
+			require.NoError(t, err)
+			require.Equal(t, keys[rank], string(k))
+			if rank > 0 {
+				k, _, err = cursor.Prev()
+				require.NoError(t, err)
+				require.Equal(t, keys[rank-1], string(k))
+			}
+		}
+
+		var zero, max [HASHSIZE]byte
+		for i := range max {
+			max[i] = 0xff
+		}
+		k, _, err := cursor.Seek(zero)
+		require.NoError(t, err)
+		require.Equal(t, keys[0], string(k))
+		_, _, err = cursor.Seek(max)
+		require.Equal(t, ErrNoMoreKeys, err)
+
+		// resume iteration page by page using the hash of last returned key
+		var all []string
+		k, _, err = cursor.Seek(zero)
+		for err == nil {
+			for j := 0; j < 100 && err == nil; j++ {
+				all = append(all, string(k))
+				k, _, err = cursor.Next()
+			}
+			if err == nil {
+				next := tree.store.hash.sum(k)
+				cursor = tree.Cursor()
+				k, _, err = cursor.Seek(next)
+			}
+		}
+		require.Equal(t, ErrNoMoreKeys, err)
+		require.Equal(t, keys, all)
+	}
+	check(tree)
+
+	require.NoError(t, tree.Commit())
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	check(tree) // partially loaded tree
+}
@@ -0,0 +1,183 @@
+package graviton
+
+import "sync"
+import "context"
+import "runtime"
+
+// DiffOptions controls DiffParallel
+type DiffOptions struct {
+	Workers int  // number of goroutines diffing subtrees, default is number of CPUs
+	Depth   int  // differing subtrees at this depth are diffed in parallel, default is 8 ie. upto 256 subtrees
+	Ordered bool // if set, changes are delivered in same order as DiffChanges, otherwise in any order
+}
+
+const diff_batch_size = 256 // changes are sent from workers in batches
+
+// DiffParallel diffs 2 trees same as DiffContext, but differing subtrees below opts.Depth are diffed by a pool of
+// workers, which helps on cold disk stores where diffs are bound by I/O latency. Handler is always called from the
+// calling goroutine, so it need not be thread safe. Trees must not be modified while being diffed.
+func DiffParallel(ctx context.Context, base_tree, head_tree *Tree, opts DiffOptions, handler func(Change) error) (err error) {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = 8
+	}
+	if opts.Depth > HASHSIZE_BITS {
+		opts.Depth = HASHSIZE_BITS
+	}
+
+	// top of both trees is walked here, so as workers never load shared nodes
+	dt := diffTree{base_tree: base_tree, head_tree: head_tree}
+	var tasks []diff_frame
+	if err = dt.split(base_tree.root, head_tree.root, 0, opts.Depth, &tasks); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errlock sync.Mutex
+	var firsterr error
+	fail := func(err error) {
+		errlock.Lock()
+		if firsterr == nil {
+			firsterr = err
+		}
+		errlock.Unlock()
+		cancel()
+	}
+
+	// in ordered mode every task has its own channel, which is drained in task order
+	unordered := make(chan []Change, opts.Workers)
+	results := make([]chan []Change, len(tasks))
+	for i := range results {
+		results[i] = unordered
+		if opts.Ordered {
+			results[i] = make(chan []Change, 4)
+		}
+	}
+
+	queue := make(chan int, len(tasks))
+	for i := range tasks {
+		queue <- i
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers && w < len(tasks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := dt.diff_task(ctx, tasks[i], results[i]); err != nil {
+					fail(err)
+				}
+				if opts.Ordered {
+					close(results[i])
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(unordered)
+	}()
+
+	deliver := func(results chan []Change) error {
+		for batch := range results {
+			for _, c := range batch {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := handler(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if opts.Ordered {
+		for i := range results {
+			if err = deliver(results[i]); err != nil {
+				break
+			}
+		}
+	} else {
+		err = deliver(unordered)
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	cancel()
+	for range unordered { // let the workers finish
+	}
+	if firsterr != nil { // worker errors are more relevant than the cancellation caused by them
+		return firsterr
+	}
+	return err
+}
+
+// collect pairs of differing subtrees at depth, in the order in which diff visits them
+func (dt *diffTree) split(base_node, head_node node, depth, limit int, tasks *[]diff_frame) (err error) {
+	base_inner, ok1 := base_node.(*inner)
+	head_inner, ok2 := head_node.(*inner)
+	if !ok1 || !ok2 || depth >= limit {
+		if base_node != nil || head_node != nil {
+			*tasks = append(*tasks, diff_frame{base_node: base_node, head_node: head_node})
+		}
+		return nil
+	}
+
+	var base_hash, head_hash []byte
+	if base_hash, err = base_inner.Hash(dt.base_tree.store); err != nil {
+		return
+	}
+	if head_hash, err = head_inner.Hash(dt.head_tree.store); err != nil {
+		return
+	}
+	if string(base_hash) == string(head_hash) {
+		return nil
+	}
+	if err = dt.split(base_inner.left, head_inner.left, depth+1, limit, tasks); err != nil {
+		return
+	}
+	return dt.split(base_inner.right, head_inner.right, depth+1, limit, tasks)
+}
+
+// diff a pair of subtrees, changes are sent in batches
+func (dt *diffTree) diff_task(ctx context.Context, task diff_frame, results chan<- []Change) (err error) {
+	worker := diffTree{base_tree: dt.base_tree, head_tree: dt.head_tree, stack: []diff_frame{task}}
+	send := func(batch []Change) error {
+		select {
+		case results <- batch:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var batch []Change
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var c Change
+		if c, err = worker.next(); err == ErrNoMoreKeys {
+			break
+		} else if err != nil {
+			return
+		}
+		if batch = append(batch, c); len(batch) == diff_batch_size {
+			if err = send(batch); err != nil {
+				return
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		return send(batch)
+	}
+	return nil
+}
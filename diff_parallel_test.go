@@ -0,0 +1,105 @@
+package graviton
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestDiffParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_diff_parallel")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	for i := 0; i < 5000; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, tree.Commit())
+	for i := 0; i < 5000; i += 4 {
+		require.NoError(t, tree.Delete([]byte(fmt.Sprintf("key%d", i))))
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i+1)), []byte("changed")))
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("new%d", i)), []byte("value")))
+	}
+	require.NoError(t, tree.Commit())
+
+	// trees are loaded again for every diff, so as nodes are loaded by workers
+	load := func() (base_tree, head_tree *Tree) {
+		gv1, err := store.LoadSnapshot(1)
+		require.NoError(t, err)
+		base_tree, err = gv1.GetTree("root")
+		require.NoError(t, err)
+		gv2, err := store.LoadSnapshot(2)
+		require.NoError(t, err)
+		head_tree, err = gv2.GetTree("root")
+		require.NoError(t, err)
+		return
+	}
+
+	base_tree, head_tree := load()
+	var expected []Change
+	require.NoError(t, DiffChanges(base_tree, head_tree, func(c Change) { expected = append(expected, c) }))
+	require.Len(t, expected, 3*1250)
+
+	for _, opts := range []DiffOptions{{}, {Ordered: true}, {Workers: 1, Depth: 1, Ordered: true}, {Workers: 16, Depth: 12}, {Depth: 300, Ordered: true}} {
+		base_tree, head_tree := load()
+		var changes []Change
+		require.NoError(t, DiffParallel(context.Background(), base_tree, head_tree, opts, func(c Change) error {
+			changes = append(changes, c)
+			return nil
+		}))
+		if opts.Ordered {
+			require.Equal(t, expected, changes, "%+v", opts)
+		} else {
+			require.ElementsMatch(t, expected, changes, "%+v", opts)
+		}
+	}
+
+	// identical trees
+	base_tree, _ = load()
+	require.NoError(t, DiffParallel(context.Background(), base_tree, base_tree, DiffOptions{}, func(c Change) error {
+		return xerrors.New("no changes expected")
+	}))
+
+	// handler errors and cancellation stop the diff
+	for _, ordered := range []bool{false, true} {
+		stop := xerrors.New("stop")
+		count := 0
+		base_tree, head_tree = load()
+		require.Equal(t, stop, DiffParallel(context.Background(), base_tree, head_tree, DiffOptions{Ordered: ordered}, func(c Change) error {
+			if count++; count == 100 {
+				return stop
+			}
+			return nil
+		}))
+		require.Equal(t, 100, count)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		count = 0
+		base_tree, head_tree = load()
+		err = DiffParallel(ctx, base_tree, head_tree, DiffOptions{Ordered: ordered}, func(c Change) error {
+			if count++; count == 100 {
+				cancel()
+			}
+			return nil
+		})
+		require.True(t, xerrors.Is(err, context.Canceled))
+		require.Equal(t, 100, count)
+	}
+
+	// storage errors of workers are returned
+	base_tree, head_tree = load()
+	head_tree.root.left.(*inner).findex = 1000000000
+	head_tree.root.left.(*inner).loaded_partial = true
+	require.Error(t, DiffParallel(context.Background(), base_tree, head_tree, DiffOptions{Depth: 1}, func(c Change) error { return nil }))
+}
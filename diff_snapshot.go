@@ -0,0 +1,79 @@
+package graviton
+
+import "fmt"
+
+// TreeChange is a change of a key of a named tree, found by DiffSnapshots
+type TreeChange struct {
+	Tree string // name of tree
+	Change
+}
+
+// DiffSnapshots finds all changes of all trees between snapshots a and b of the same store. Version roots of both
+// snapshots are diffed first to find trees which were created, removed or committed in between, and then only those
+// trees are diffed. Keys of created trees are reported as inserted and keys of removed trees as deleted.
+// Trees are visited in hash order of their names, handler errors stop the diff and are returned.
+func DiffSnapshots(a, b *Snapshot, handler func(TreeChange) error) (err error) {
+	if a.store != b.store {
+		return fmt.Errorf("snapshots belong to different stores")
+	}
+	it := NewDiffIterator(&Tree{store: a.store, root: a.vroot}, &Tree{store: b.store, root: b.vroot})
+	for {
+		var c Change
+		if c, err = it.Next(); err != nil {
+			if err == ErrNoMoreKeys {
+				return nil
+			}
+			return
+		}
+
+		treename, ok := tree_version_key(c)
+		if !ok {
+			continue
+		}
+		if err = diff_snapshot_tree(a, b, treename, handler); err != nil {
+			return
+		}
+	}
+}
+
+// deleted entries carry the version in old value
+func tree_version_key(c Change) (string, bool) {
+	if c.Kind == Deleted {
+		return tree_version_entry(c.Key, c.OldValue)
+	}
+	return tree_version_entry(c.Key, c.NewValue)
+}
+
+// diff highest versions of a tree in both snapshots, missing trees are empty
+func diff_snapshot_tree(a, b *Snapshot, treename string, handler func(TreeChange) error) error {
+	base_tree, err := snapshot_tree(a, treename)
+	if err != nil {
+		return err
+	}
+	head_tree, err := snapshot_tree(b, treename)
+	if err != nil {
+		return err
+	}
+
+	it := NewDiffIterator(base_tree, head_tree)
+	for {
+		c, err := it.Next()
+		if err == ErrNoMoreKeys {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = handler(TreeChange{Tree: treename, Change: c}); err != nil {
+			return err
+		}
+	}
+}
+
+func snapshot_tree(s *Snapshot, treename string) (*Tree, error) {
+	version, err := s.GetTreeHighestVersion(treename)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTreeWithVersion(treename, version)
+}
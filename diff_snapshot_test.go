@@ -0,0 +1,88 @@
+package graviton
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+
+	var trees []*Tree
+	for i := 0; i < 4; i++ {
+		tree, err := gv.GetTree(fmt.Sprintf("tree%d", i))
+		require.NoError(t, err)
+		for j := 0; j < 100; j++ {
+			require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d", j))))
+		}
+		trees = append(trees, tree)
+	}
+	_, err = Commit(trees...) // version 1
+	require.NoError(t, err)
+
+	// tree0 is modified, tree1 is changed and changed back, tree2 is untouched and tree4 is created
+	require.NoError(t, trees[0].Put([]byte("key0"), []byte("changed")))
+	require.NoError(t, trees[0].Delete([]byte("key1")))
+	require.NoError(t, trees[0].Put([]byte("new"), []byte("value")))
+	require.NoError(t, trees[1].Put([]byte("key0"), []byte("changed")))
+	_, err = Commit(trees[0], trees[1]) // version 2
+	require.NoError(t, err)
+	require.NoError(t, trees[1].Put([]byte("key0"), []byte("value0")))
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree4, err := gv.GetTree("tree4")
+	require.NoError(t, err)
+	require.NoError(t, tree4.Put([]byte("key"), []byte("value")))
+	_, err = Commit(trees[1], tree4) // version 3
+	require.NoError(t, err)
+
+	v1, err := store.LoadSnapshot(1)
+	require.NoError(t, err)
+	v3, err := store.LoadSnapshot(3)
+	require.NoError(t, err)
+
+	collect := func(a, b *Snapshot) map[string]TreeChange {
+		changes := map[string]TreeChange{}
+		require.NoError(t, DiffSnapshots(a, b, func(c TreeChange) error {
+			changes[c.Tree+"/"+string(c.Key)] = c
+			return nil
+		}))
+		return changes
+	}
+
+	changes := collect(v1, v3)
+	require.Len(t, changes, 4)
+	require.Equal(t, TreeChange{Tree: "tree0", Change: Change{Kind: Modified, Key: []byte("key0"), OldValue: []byte("value0"), NewValue: []byte("changed")}}, changes["tree0/key0"])
+	require.Equal(t, TreeChange{Tree: "tree0", Change: Change{Kind: Deleted, Key: []byte("key1"), OldValue: []byte("value1")}}, changes["tree0/key1"])
+	require.Equal(t, TreeChange{Tree: "tree0", Change: Change{Kind: Inserted, Key: []byte("new"), NewValue: []byte("value")}}, changes["tree0/new"])
+	require.Equal(t, TreeChange{Tree: "tree4", Change: Change{Kind: Inserted, Key: []byte("key"), NewValue: []byte("value")}}, changes["tree4/key"])
+
+	// reverse diff removes tree4
+	changes = collect(v3, v1)
+	require.Len(t, changes, 4)
+	require.Equal(t, Deleted, changes["tree4/key"].Kind)
+	require.Equal(t, Inserted, changes["tree0/key1"].Kind)
+
+	require.Len(t, collect(v3, v3), 0)
+
+	// handler errors stop the diff
+	stop := xerrors.New("stop")
+	count := 0
+	require.Equal(t, stop, DiffSnapshots(v1, v3, func(c TreeChange) error {
+		count++
+		return stop
+	}))
+	require.Equal(t, 1, count)
+
+	other, err := NewMemStore()
+	require.NoError(t, err)
+	ov, err := other.LoadSnapshot(0)
+	require.NoError(t, err)
+	require.Error(t, DiffSnapshots(v1, ov, func(c TreeChange) error { return nil }))
+}
@@ -6,11 +6,12 @@ package graviton
 //import "errors"
 //import "os"
 import "bytes"
+import "context"
 
 //import "sync"
 //import "encoding/binary"
 
-//import "golang.org/x/xerrors"
+import "golang.org/x/xerrors"
 
 //Cursor represents an iterator that can traverse over all key/value pairs in a tree in hash sorted order.
 //Cursors can be obtained from a tree and are valid as long as the tree is valid.
@@ -18,22 +19,169 @@ import "bytes"
 //Changing tree (before committing) while traversing with a cursor may cause it to be invalidated and return unexpected keys and/or values. You must reposition your cursor after mutating data.
 type diffTree struct {
 	base_tree, head_tree *Tree
+
+	stack   []diff_frame // pending work, top of stack is processed first
+	pending []Change     // changes found but not yet returned
+	err     error        // once an error occurs, it is returned forever
+}
+
+// a frame either compares 2 nodes or walks the remaining keys of a subtree, reporting them all as kind
+type diff_frame struct {
+	base_node, head_node node
+	walk                 *Cursor
+	kind                 ChangeKind
+	skip                 []byte // key which must not be reported by the walk
 }
 
 // All changes are reported of this type, deleted, modified, inserted
 type DiffHandler func(k, v []byte)
 
+// ChangeKind is the kind of a change reported by DiffChanges
+type ChangeKind byte
+
+const (
+	Inserted ChangeKind = iota + 1 // key exists only in head tree
+	Deleted                        // key exists only in base tree
+	Modified                       // key exists in both trees with different values
+)
+
+func (kind ChangeKind) String() string {
+	switch kind {
+	case Inserted:
+		return "inserted"
+	case Deleted:
+		return "deleted"
+	case Modified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Change describes a key which differs between base and head trees, OldValue is nil for insertions and NewValue is
+// nil for deletions
+type Change struct {
+	Kind     ChangeKind
+	Key      []byte
+	OldValue []byte // value in base tree
+	NewValue []byte // value in head tree
+}
+
+// ChangeHandler receives every change found by DiffChanges
+type ChangeHandler func(Change)
+
 // This function can be used to diff 2 trees and thus find all the keys which have been deleted, modified, inserted.
 // The algorithm is linear time in the number of changes. Eg. a tree with billion KVs can be diffed with parent almost instantaneously.
 // Todo : API redesign, should we give only keys, since values can be obtained later on !!
 func Diff(base_tree, head_tree *Tree, deleted, modified, inserted DiffHandler) (err error) {
-	dt := diffTree{base_tree: base_tree, head_tree: head_tree}
-	return dt.changes_internal(base_tree.root, head_tree.root, deleted, modified, inserted)
+	return DiffChanges(base_tree, head_tree, func(c Change) {
+		switch {
+		case c.Kind == Deleted && deleted != nil:
+			deleted(c.Key, c.OldValue)
+		case c.Kind == Modified && modified != nil:
+			modified(c.Key, c.NewValue)
+		case c.Kind == Inserted && inserted != nil:
+			inserted(c.Key, c.NewValue)
+		}
+	})
 }
 
-// extract changes one bye one
-func (dt *diffTree) changes_internal(base_node, head_node *inner, deleted, modified, inserted DiffHandler) (err error) {
+// DiffChanges diffs 2 trees same as Diff, but every change carries both old and new values and its kind, so
+// changes can be logged or reverted without looking up the keys again
+func DiffChanges(base_tree, head_tree *Tree, handler ChangeHandler) (err error) {
+	return DiffContext(context.Background(), base_tree, head_tree, func(c Change) error {
+		handler(c)
+		return nil
+	})
+}
 
+// DiffContext diffs 2 trees same as DiffChanges, but stops as soon as ctx is done or handler returns an error, which
+// is then returned. Storage errors met while diffing are always returned
+func DiffContext(ctx context.Context, base_tree, head_tree *Tree, handler func(Change) error) (err error) {
+	it := NewDiffIterator(base_tree, head_tree)
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var c Change
+		if c, err = it.Next(); err != nil {
+			if err == ErrNoMoreKeys {
+				return nil
+			}
+			return
+		}
+		if err = handler(c); err != nil {
+			return
+		}
+	}
+}
+
+// DiffIterator pulls changes between 2 trees one by one, in the same order as DiffChanges reports them.
+// Changes are only computed when asked for, so iteration can be paused or abandoned at any point.
+type DiffIterator struct {
+	dt diffTree
+}
+
+// NewDiffIterator returns an iterator over changes needed to convert base tree into head tree
+func NewDiffIterator(base_tree, head_tree *Tree) *DiffIterator {
+	it := &DiffIterator{dt: diffTree{base_tree: base_tree, head_tree: head_tree}}
+	it.dt.stack = append(it.dt.stack, diff_frame{base_node: base_tree.root, head_node: head_tree.root})
+	return it
+}
+
+// Next returns the next change, ErrNoMoreKeys is returned after all changes have been returned.
+// Keys and values are only valid for the life of the trees.
+func (it *DiffIterator) Next() (Change, error) {
+	return it.dt.next()
+}
+
+func (dt *diffTree) next() (c Change, err error) {
+	for dt.err == nil {
+		if len(dt.pending) > 0 {
+			c = dt.pending[0]
+			dt.pending = dt.pending[1:]
+			return c, nil
+		}
+		if len(dt.stack) == 0 {
+			return c, ErrNoMoreKeys
+		}
+
+		top := len(dt.stack) - 1
+		frame := dt.stack[top]
+		if frame.walk != nil {
+			var k, v []byte
+			if k, v, err = frame.walk.Next(); err == ErrNoMoreKeys {
+				dt.stack = dt.stack[:top]
+			} else if err != nil {
+				dt.err = err
+			} else {
+				dt.add(frame.kind, frame.skip, k, v)
+			}
+			continue
+		}
+		dt.stack = dt.stack[:top]
+		dt.err = dt.compare_nodes(frame.base_node, frame.head_node)
+	}
+	return c, dt.err
+}
+
+// queue a change of a key found while walking a subtree
+func (dt *diffTree) add(kind ChangeKind, skip []byte, k, v []byte) {
+	if skip != nil && bytes.Compare(k, skip) == 0 {
+		return
+	}
+	if kind == Inserted {
+		dt.pending = append(dt.pending, Change{Kind: Inserted, Key: k, NewValue: v})
+	} else {
+		dt.pending = append(dt.pending, Change{Kind: Deleted, Key: k, OldValue: v})
+	}
+}
+
+func (dt *diffTree) modified(k, oldv, newv []byte) {
+	dt.pending = append(dt.pending, Change{Kind: Modified, Key: k, OldValue: oldv, NewValue: newv})
+}
+
+// extract changes one bye one, children are pushed so as left is processed first
+func (dt *diffTree) changes_internal(base_node, head_node *inner) (err error) {
 	var base_hash, head_hash []byte
 	if base_hash, err = base_node.Hash(dt.base_tree.store); err == nil {
 		if head_hash, err = head_node.Hash(dt.head_tree.store); err == nil {
@@ -49,176 +197,130 @@ func (dt *diffTree) changes_internal(base_node, head_node *inner, deleted, modif
 		return
 	}
 
-	if err = dt.compare_nodes(base_node.left, head_node.left, deleted, modified, inserted); err != nil {
+	dt.stack = append(dt.stack, diff_frame{base_node: base_node.right, head_node: head_node.right})
+	dt.stack = append(dt.stack, diff_frame{base_node: base_node.left, head_node: head_node.left})
+	return nil
+}
+
+// report all keys of a subtree as kind, skipping key skip. first key is found right away, rest are found by next
+func (dt *diffTree) walk(tree *Tree, n node, kind ChangeKind, skip []byte) (err error) {
+	c := tree.Cursor()
+	k, v, err := c.next_internal(n, false)
+	if err == ErrNoMoreKeys {
+		return nil
+	}
+	if err != nil {
 		return
 	}
-	return dt.compare_nodes(base_node.right, head_node.right, deleted, modified, inserted)
+	dt.add(kind, skip, k, v)
+	dt.stack = append(dt.stack, diff_frame{walk: &c, kind: kind, skip: skip})
+	return nil
 }
 
-func (dt *diffTree) compare_nodes(base_node, head_node node, deleted, modified, inserted DiffHandler) (err error) {
+// lookup a key in the other tree, missing keys return nil value and no error
+func lookup(tree *Tree, key []byte) (v []byte, found bool, err error) {
+	v, err = tree.Get(key)
+	if err == nil {
+		return v, true, nil
+	}
+	if xerrors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	return nil, false, err
+}
 
-	var k, v []byte
+// compare 2 nodes at same position, changes found are queued and subtrees needing more work are pushed on stack
+func (dt *diffTree) compare_nodes(base_node, head_node node) (err error) {
 	if base_node == nil && head_node == nil { // nothing to do on left side
 		return
 	}
 
 	if base_node == nil && head_node != nil { // all the head nodes were added
-		c := dt.head_tree.Cursor()
-		for k, v, err = c.next_internal(head_node, false); err == nil; k, v, err = c.Next() {
-			if inserted != nil {
-				inserted(k, v)
-			}
-		}
-		if err == ErrNoMoreKeys {
-			return nil
-		}
-		//return err
-
+		return dt.walk(dt.head_tree, head_node, Inserted, nil)
 	} else if base_node != nil && head_node == nil { // all the base nodes were deleted
-		c := dt.base_tree.Cursor()
-		for k, v, err = c.next_internal(base_node, false); err == nil; k, v, err = c.Next() {
-			if deleted != nil {
-				deleted(k, v)
-			}
-		}
-		if err == ErrNoMoreKeys {
-			return nil
-		}
-		//return err
+		return dt.walk(dt.base_tree, base_node, Deleted, nil)
+	}
 
-	} else {
+	// both sides are not nil
+	base_type := getNodeType(base_node)
+	head_type := getNodeType(head_node)
+
+	if err = base_node.load_partial(dt.base_tree.store); err != nil {
+		return err
+	}
+	if err = head_node.load_partial(dt.head_tree.store); err != nil {
+		return err
+	}
 
-		// both sides are not nil
-		base_type := getNodeType(base_node)
-		head_type := getNodeType(head_node)
+	if base_type == innerNODE && head_type == innerNODE {
+		return dt.changes_internal(base_node.(*inner), head_node.(*inner))
+	} else if base_type == leafNODE && head_type == leafNODE {
 
-		if err = base_node.load_partial(dt.base_tree.store); err != nil {
-			return err
+		// if both leafs are different, process else leafs are same nothing to do
+		var base_hash, head_hash []byte
+		if base_hash, err = base_node.Hash(dt.base_tree.store); err != nil {
+			return
 		}
-		if err = head_node.load_partial(dt.head_tree.store); err != nil {
-			return err
+		if head_hash, err = head_node.Hash(dt.head_tree.store); err != nil {
+			return
+		}
+		if bytes.Compare(base_hash, head_hash) == 0 {
+			return nil
 		}
 
-		if base_type == innerNODE && head_type == innerNODE {
-			return dt.changes_internal(base_node.(*inner), head_node.(*inner), deleted, modified, inserted)
-		} else if base_type == leafNODE && head_type == leafNODE {
-
-			// if both leafs are different, process else leafs are same nothing to do
-
-			var base_hash, head_hash []byte
-			if base_hash, err = base_node.Hash(dt.base_tree.store); err == nil {
-				if head_hash, err = head_node.Hash(dt.head_tree.store); err == nil {
-
-					if bytes.Compare(base_hash, head_hash) != 0 {
-
-						base_leaf := base_node.(*leaf)
-						head_leaf := head_node.(*leaf)
-
-						// if keys are same, then values are different or values are updates
-						if bytes.Compare(base_leaf.key, head_leaf.key) == 0 {
-							if modified != nil {
-								//fmt.Printf("110 key %s basevalue %s  headvalue %s\n", string(base_leaf.key), string(base_leaf.value), string(head_leaf.value))
-								modified(head_leaf.key, head_leaf.value)
-							}
-						} else {
-							// base leaf was deleted
-							// head leaf was inserted
-							if deleted != nil {
-								deleted(base_leaf.key, base_leaf.value)
-							}
-							if inserted != nil {
-								inserted(head_leaf.key, head_leaf.value)
-
-							}
-						}
-					}
-					return nil
-
-				}
-
-			}
-
-			// we are if any hash err and we return it
-			//return err
-
-			// one of nodes is inner and one of the node is leaf
-		} else if base_type == innerNODE { // base type is inner node, head type is leaf node
-			head_leaf := head_node.(*leaf)
-			head_leaf.Hash(dt.head_tree.store) // if partial complete the node
-
-			// check whether base tree contains this node
-			v, err := dt.base_tree.Get(head_leaf.key)
-			if err == nil { // key was found
-				if bytes.Compare(v, head_leaf.value) == 0 { // same key,value exist, we must not report it
-
-				} else { // same key, but value changed, we must a modification
-					if modified != nil {
-						//fmt.Printf("140 key %s basevalue %s  headvalue %s\n", string(head_leaf.key), string(v), string(head_leaf.value))
-						modified(head_leaf.key, head_leaf.value)
-					}
-				}
-			} else { // either key was not found or some error occurred
-				if inserted != nil {
-					inserted(head_leaf.key, head_leaf.value)
-				}
-			}
-
-			err = nil
-
-			// now the entire base tree must be searched, limited at specific node, and the head leaf key skipped
-			c := dt.base_tree.Cursor()
-			for k, v, err = c.next_internal(base_node, false); err == nil; k, v, err = c.Next() {
-				if bytes.Compare(k, head_leaf.key) != 0 {
-					if deleted != nil {
-						deleted(k, v)
-					}
-				}
-			}
-			if err == ErrNoMoreKeys {
-				return nil
-			}
-			//return err
-
-		} else { // base type is leaf node, head type is inner node
-
-			base_leaf := base_node.(*leaf)
-			base_leaf.Hash(dt.base_tree.store) // if partial complete the node
-
-			// check whether base tree contains this node
-			v, err := dt.head_tree.Get(base_leaf.key)
-			if err == nil { // key was found
-				if bytes.Compare(v, base_leaf.value) == 0 { // same key,value exist, we must not report it
-
-				} else { // same key, but value changed, we must a modification
-					if modified != nil {
-						//fmt.Printf("182 key %s basevalue %s  headvalue %s\n", string(base_leaf.key), string(v), string(base_leaf.value))
-						modified(base_leaf.key, v)
-					}
-				}
-			} else { // either key was not found or some error occurred
-				if deleted != nil {
-					deleted(base_leaf.key, base_leaf.value)
-				}
-			}
+		base_leaf := base_node.(*leaf)
+		head_leaf := head_node.(*leaf)
 
-			err = nil
+		// if keys are same, then values are different or values are updates
+		if bytes.Compare(base_leaf.key, head_leaf.key) == 0 {
+			dt.modified(head_leaf.key, base_leaf.value, head_leaf.value)
+			return nil
+		}
+		// base leaf was deleted
+		// head leaf was inserted
+		dt.add(Deleted, nil, base_leaf.key, base_leaf.value)
+		dt.add(Inserted, nil, head_leaf.key, head_leaf.value)
+		return nil
+
+		// one of nodes is inner and one of the node is leaf
+	} else if base_type == innerNODE { // base type is inner node, head type is leaf node
+		head_leaf := head_node.(*leaf)
+		if _, err = head_leaf.Hash(dt.head_tree.store); err != nil { // if partial complete the node
+			return
+		}
 
-			// now the entire base tree must be searched, limited at specific node, and the head leaf key skipped
-			c := dt.head_tree.Cursor()
-			for k, v, err = c.next_internal(head_node, false); err == nil; k, v, err = c.Next() {
-				if bytes.Compare(k, base_leaf.key) != 0 {
-					if inserted != nil {
-						inserted(k, v)
-					}
-				}
-			}
-			if err == ErrNoMoreKeys {
-				return nil
-			}
-			//return err
+		// check whether base tree contains this node
+		v, found, err := lookup(dt.base_tree, head_leaf.key)
+		if err != nil {
+			return err
 		}
+		if !found {
+			dt.add(Inserted, nil, head_leaf.key, head_leaf.value)
+		} else if bytes.Compare(v, head_leaf.value) != 0 { // same key, but value changed, we must a modification
+			dt.modified(head_leaf.key, v, head_leaf.value)
+		} // same key,value exist, we must not report it
+
+		// now the entire base tree must be searched, limited at specific node, and the head leaf key skipped
+		return dt.walk(dt.base_tree, base_node, Deleted, head_leaf.key)
+	}
 
+	// base type is leaf node, head type is inner node
+	base_leaf := base_node.(*leaf)
+	if _, err = base_leaf.Hash(dt.base_tree.store); err != nil { // if partial complete the node
+		return
 	}
 
-	return err
+	// check whether head tree contains this node
+	v, found, err := lookup(dt.head_tree, base_leaf.key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		dt.add(Deleted, nil, base_leaf.key, base_leaf.value)
+	} else if bytes.Compare(v, base_leaf.value) != 0 { // same key, but value changed, we must a modification
+		dt.modified(base_leaf.key, base_leaf.value, v)
+	} // same key,value exist, we must not report it
+
+	// now the entire head tree must be searched, limited at specific node, and the base leaf key skipped
+	return dt.walk(dt.head_tree, head_node, Inserted, base_leaf.key)
 }
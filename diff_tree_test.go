@@ -1,6 +1,7 @@
 package graviton
 
 import (
+	"context"
 	"encoding/base64"
 	"math/rand"
 	"reflect"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 )
 
 func randString(len int) string {
@@ -272,19 +274,19 @@ func TestDiffTree_Single_Key_error_cases(t *testing.T) {
 	head_tree.root.loaded_partial = true
 
 	dt := diffTree{base_tree: base_tree, head_tree: head_tree}
-	require.Error(t, dt.compare_nodes(base_tree.root, head_tree.root, nil, nil, nil))
+	require.Error(t, dt.compare_nodes(base_tree.root, head_tree.root))
 
 	base_tree, _ = gv1.GetTree("root")
 	base_tree.root.findex = 1000000000
 	base_tree.root.loaded_partial = true
-	require.Error(t, dt.compare_nodes(base_tree.root, head_tree.root, nil, nil, nil))
+	require.Error(t, dt.compare_nodes(base_tree.root, head_tree.root))
 
 	base_tree, _ = gv1.GetTree("root")
 	head_tree, _ = gv2.GetTree("root")
 
 	head_tree.root.left.(*inner).findex = 1000000000
 	head_tree.root.left.(*inner).loaded_partial = true
-	require.Error(t, dt.compare_nodes(nil, head_tree.root, nil, nil, nil))
+	require.Error(t, dt.compare_nodes(nil, head_tree.root))
 
 	//	t.Logf("basehash %x", base_tree.Hash())
 	//	t.Logf("headhash %x", head_tree.Hash())
@@ -292,3 +294,228 @@ func TestDiffTree_Single_Key_error_cases(t *testing.T) {
 	require.Error(t, Diff(base_tree, head_tree, nil, nil, nil))
 
 }
+
+// changes must carry old and new values, applying them to base must give head and reverting them must give base
+func TestDiffChanges(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	base := map[string]string{}
+	for i := 0; i < 2000; i++ {
+		key, value := randString(16), randString(8)
+		base[key] = value
+		require.NoError(t, tree.Put([]byte(key), []byte(value)))
+	}
+	require.NoError(t, tree.Commit())
+
+	head := map[string]string{}
+	for k, v := range base {
+		head[k] = v
+	}
+	i := 0
+	for k := range base {
+		switch i % 7 {
+		case 0:
+			require.NoError(t, tree.Delete([]byte(k)))
+			delete(head, k)
+		case 1:
+			head[k] = randString(8)
+			require.NoError(t, tree.Put([]byte(k), []byte(head[k])))
+		}
+		i++
+	}
+	for i := 0; i < 300; i++ {
+		key, value := randString(16), randString(8)
+		head[key] = value
+		require.NoError(t, tree.Put([]byte(key), []byte(value)))
+	}
+	require.NoError(t, tree.Commit())
+
+	gv1, err := store.LoadSnapshot(1)
+	require.NoError(t, err)
+	base_tree, err := gv1.GetTree("root")
+	require.NoError(t, err)
+	gv2, err := store.LoadSnapshot(2)
+	require.NoError(t, err)
+	head_tree, err := gv2.GetTree("root")
+	require.NoError(t, err)
+
+	check := func(base_tree, head_tree *Tree, base, head map[string]string) {
+		applied := map[string]string{}
+		for k, v := range base {
+			applied[k] = v
+		}
+		kinds := map[ChangeKind]int{}
+		require.NoError(t, DiffChanges(base_tree, head_tree, func(c Change) {
+			kinds[c.Kind]++
+			k := string(c.Key)
+			switch c.Kind {
+			case Inserted:
+				require.Nil(t, c.OldValue)
+				_, exists := base[k]
+				require.False(t, exists)
+			case Deleted, Modified:
+				require.Equal(t, base[k], string(c.OldValue))
+			default:
+				t.Fatalf("unknown change kind %s", c.Kind)
+			}
+			if c.Kind == Deleted {
+				require.Nil(t, c.NewValue)
+				delete(applied, k)
+			} else {
+				require.NotEqual(t, string(c.OldValue), string(c.NewValue))
+				applied[k] = string(c.NewValue)
+			}
+		}))
+		require.Equal(t, head, applied)
+		require.True(t, kinds[Inserted] > 0 && kinds[Deleted] > 0 && kinds[Modified] > 0)
+	}
+	check(base_tree, head_tree, base, head)
+	check(head_tree, base_tree, head, base)
+
+	require.Equal(t, "inserted", Inserted.String())
+	require.Equal(t, "unknown", ChangeKind(0).String())
+}
+
+func TestDiffContext(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, tree.Put([]byte(randString(16)), []byte(randString(8))))
+	}
+	require.NoError(t, tree.Commit())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, tree.Put([]byte(randString(16)), []byte(randString(8))))
+	}
+	require.NoError(t, tree.Commit())
+
+	gv1, err := store.LoadSnapshot(1)
+	require.NoError(t, err)
+	base_tree, err := gv1.GetTree("root")
+	require.NoError(t, err)
+	gv2, err := store.LoadSnapshot(2)
+	require.NoError(t, err)
+	head_tree, err := gv2.GetTree("root")
+	require.NoError(t, err)
+
+	count := 0
+	require.NoError(t, DiffContext(context.Background(), base_tree, head_tree, func(c Change) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, 100, count)
+
+	// handler errors stop the diff
+	stop := xerrors.New("stop")
+	count = 0
+	require.Equal(t, stop, DiffContext(context.Background(), base_tree, head_tree, func(c Change) error {
+		count++
+		if count == 10 {
+			return stop
+		}
+		return nil
+	}))
+	require.Equal(t, 10, count)
+
+	// cancellation stops the diff
+	ctx, cancel := context.WithCancel(context.Background())
+	count = 0
+	err = DiffContext(ctx, base_tree, head_tree, func(c Change) error {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return nil
+	})
+	require.True(t, xerrors.Is(err, context.Canceled))
+	require.Equal(t, 10, count)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	require.True(t, xerrors.Is(DiffContext(ctx, base_tree, head_tree, func(c Change) error { return nil }), context.DeadlineExceeded))
+
+	// storage errors are returned
+	head_tree, err = gv2.GetTree("root")
+	require.NoError(t, err)
+	head_tree.root.left.(*inner).findex = 1000000000
+	head_tree.root.left.(*inner).loaded_partial = true
+	require.Error(t, DiffContext(context.Background(), base_tree, head_tree, func(c Change) error { return nil }))
+}
+
+func TestDiffIterator(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	var keys []string
+	for i := 0; i < 3000; i++ {
+		keys = append(keys, randString(16))
+		require.NoError(t, tree.Put([]byte(keys[i]), []byte(randString(8))))
+	}
+	require.NoError(t, tree.Commit())
+	for i, key := range keys {
+		switch i % 5 {
+		case 0:
+			require.NoError(t, tree.Delete([]byte(key)))
+		case 1:
+			require.NoError(t, tree.Put([]byte(key), []byte(randString(8))))
+		case 2:
+			require.NoError(t, tree.Put([]byte(randString(16)), []byte(randString(8))))
+		}
+	}
+	require.NoError(t, tree.Commit())
+
+	gv1, err := store.LoadSnapshot(1)
+	require.NoError(t, err)
+	base_tree, err := gv1.GetTree("root")
+	require.NoError(t, err)
+	gv2, err := store.LoadSnapshot(2)
+	require.NoError(t, err)
+	head_tree, err := gv2.GetTree("root")
+	require.NoError(t, err)
+
+	var expected []Change
+	require.NoError(t, DiffChanges(base_tree, head_tree, func(c Change) { expected = append(expected, c) }))
+	require.Len(t, expected, 3*600)
+
+	// 2 iterators pulled alternately, both must return same changes in same order as callbacks
+	it1, it2 := NewDiffIterator(base_tree, head_tree), NewDiffIterator(base_tree, head_tree)
+	for _, c := range expected {
+		c1, err := it1.Next()
+		require.NoError(t, err)
+		c2, err := it2.Next()
+		require.NoError(t, err)
+		require.Equal(t, c, c1)
+		require.Equal(t, c, c2)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = it1.Next()
+		require.Equal(t, ErrNoMoreKeys, err)
+	}
+
+	// identical trees have no changes
+	_, err = NewDiffIterator(head_tree, head_tree).Next()
+	require.Equal(t, ErrNoMoreKeys, err)
+
+	// errors are sticky
+	head_tree, err = gv2.GetTree("root")
+	require.NoError(t, err)
+	head_tree.root.left.(*inner).findex = 1000000000
+	head_tree.root.left.(*inner).loaded_partial = true
+	it := NewDiffIterator(base_tree, head_tree)
+	_, err = it.Next()
+	require.Error(t, err)
+	_, err2 := it.Next()
+	require.Equal(t, err, err2)
+}
@@ -0,0 +1,95 @@
+package graviton
+
+import "time"
+
+// SyncPolicy controls when committed data is forced to stable storage ( disk stores only )
+type SyncPolicy int8
+
+const (
+	SyncNone        SyncPolicy = iota // default, data is flushed whenever OS decides, a crash may lose recent commits
+	SyncCommit                        // every commit is fsynced before Commit returns
+	SyncGroupCommit                   // concurrent commits share a single fsync, Commit returns once its version is durable
+)
+
+// StoreOptions are the parameters which can be supplied while opening a store
+type StoreOptions struct {
+	Sync             SyncPolicy
+	GroupCommitDelay time.Duration // group commit waits this long to collect more commits before syncing
+	Hash             string        // name of hash function, default is blake2s. disk stores record it and cannot be reopened with another one
+	Repair           bool          // discard versions which fail validation on open, instead of refusing to open the store
+}
+
+// Flush makes all committed data and version records of a disk store durable, whatever the sync policy is. Close
+// also flushes but cannot report errors, so callers which need to know should call Flush before Close
+func (s *Store) Flush() error {
+	if s.disk == nil {
+		return nil
+	}
+	return s.flush()
+}
+
+// flush makes all data written so far and all pending version records durable
+// data files are synced first, then version records are written and synced, so as a version record never
+// reaches the disk before the data it points to
+func (s *Store) flush() (err error) {
+	s.flushsync.Lock()
+	defer s.flushsync.Unlock()
+
+	d := s.disk
+	s.discsync.Lock()
+	first, last := d.synced_findex, d.findex
+	records := d.pending_versions
+	start := d.pending_start
+	files := make([]*datafile, 0, last-first+1) // files map is modified by writes, so handles are copied under the lock
+	for i := first; i <= last; i++ {
+		files = append(files, d.files[i])
+	}
+	s.discsync.Unlock()
+
+	for i, f := range files {
+		if err = f.handle.Sync(); err != nil {
+			return
+		}
+		if i > 0 { // newly created files also need their directory entries synced
+			if err = d.syncdirs(d.uint_to_filename(first + uint32(i))); err != nil {
+				return
+			}
+		}
+	}
+
+	if len(records) == 0 {
+		d.synced_findex = last
+		return nil
+	}
+
+	s.discsync.Lock()
+	if _, err = d.versionrootfile.WriteAt(records, int64((start-1)*8)); err == nil {
+		d.pending_versions = d.pending_versions[len(records):]
+		d.pending_start = start + uint64(len(records)/8)
+	}
+	s.discsync.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err = d.versionrootfile.Sync(); err == nil {
+		d.synced_findex = last
+		s.durable_version = start + uint64(len(records)/8) - 1
+	}
+	return
+}
+
+// wait till the version becomes durable, if no flush covering the version is in progress, perform one
+func (s *Store) groupsync(version uint64) error {
+	if s.options.GroupCommitDelay > 0 {
+		time.Sleep(s.options.GroupCommitDelay) // give other commits a chance to join this group
+	}
+
+	s.flushsync.Lock()
+	durable := s.durable_version >= version
+	s.flushsync.Unlock()
+	if durable { // some other commit has already synced us
+		return nil
+	}
+	return s.flush()
+}
@@ -0,0 +1,123 @@
+package graviton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_sync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(dir, StoreOptions{Sync: SyncCommit})
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	store.disk.files[0].size = MAX_FILE_SIZE - 64 // force a file rollover so as new directories are synced
+	for i := 0; i < 10; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+		require.NoError(t, tree.Commit())
+
+		// version record must be on disk before commit returns
+		finfo, err := os.Stat(filepath.Join(dir, "version_root.bin"))
+		require.NoError(t, err)
+		require.Equal(t, int64(8*(i+1)), finfo.Size())
+		require.Equal(t, 0, len(store.disk.pending_versions))
+		require.Equal(t, uint64(i+1), store.durable_version)
+	}
+	require.True(t, store.disk.findex >= 1)
+}
+
+func TestSyncGroupCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_groupsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(dir, StoreOptions{Sync: SyncGroupCommit, GroupCommitDelay: time.Millisecond})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			gv, err := store.LoadSnapshot(0)
+			require.NoError(t, err)
+			tree, err := gv.GetTree(fmt.Sprintf("tree%d", g))
+			require.NoError(t, err)
+			for i := 0; i < 10; i++ {
+				require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+				version, err := Commit(tree)
+				require.NoError(t, err)
+				store.flushsync.Lock()
+				require.True(t, store.durable_version >= version)
+				store.flushsync.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	finfo, err := os.Stat(filepath.Join(dir, "version_root.bin"))
+	require.NoError(t, err)
+	require.Equal(t, int64(8*80), finfo.Size())
+	store.Close()
+
+	store, err = NewDiskStore(dir)
+	require.NoError(t, err)
+	for version := uint64(1); version <= 80; version++ {
+		_, err := store.LoadSnapshot(version)
+		require.NoError(t, err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_flush")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	mstore, err := NewMemStore()
+	require.NoError(t, err)
+	require.NoError(t, mstore.Flush())
+
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	// flushes run while commits create new files
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			store.discsync.Lock()
+			store.disk.files[store.disk.findex].size = MAX_FILE_SIZE - 64
+			store.discsync.Unlock()
+			require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+			require.NoError(t, tree.Commit())
+		}
+	}()
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+		}
+		require.NoError(t, store.Flush())
+	}
+	require.NoError(t, store.Flush())
+	require.Equal(t, store.disk.findex, store.disk.synced_findex)
+	store.Close()
+}
@@ -1,7 +1,6 @@
 package graviton
 
 import "fmt"
-import "math"
 import "crypto/rand"
 
 
@@ -58,23 +57,9 @@ func (t *Tree) random(cnode node) (k, v []byte, err error) {
 	}
 }
 
-// estimate number of keys that exist in the tree
-// very crude but only used for use display
+// number of keys that exist in the tree, this used to be an estimate and is now exact
+// Deprecated: use Count
 func (t *Tree) KeyCountEstimate() (count int64) {
-	c := t.Cursor()
-
-	var depth_array []int
-	var floatsum float64
-	for _, _, err := c.First(); err == nil; _, _, err = c.Next() {
-		floatsum += float64(len(c.node_path))
-		depth_array = append(depth_array, len(c.node_path))
-		if len(depth_array) >= 20 {
-			break
-		}
-	}
-	if len(depth_array) <= 4 {
-		return int64(count)
-	}
-	avg := floatsum / float64(len(depth_array)+1)
-	return int64(math.Exp2(avg))
+	n, _ := t.Count()
+	return int64(n)
 }
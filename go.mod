@@ -1,3 +1,9 @@
 module github.com/deroproject/graviton
 
 go 1.14
+
+require (
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.14.0
+	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da
+)
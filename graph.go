@@ -62,7 +62,7 @@ func (t *Tree) graph(cnode node, w *bufio.Writer) {
 		}
 		w.WriteString(fmt.Sprintf("node [ fontsize=12 style=filled ]\n{\n"))
 		hash, _ := node.Hash(t.store)
-		keyhash := sum(node.key)
+		keyhash := t.store.hash.sum(node.key)
 		w.WriteString(fmt.Sprintf("L%x  [ fillcolor=%s label = \"L%x   %x\"  ];\n", hash, "green", hash, keyhash))
 		w.WriteString(fmt.Sprintf("}\n"))
 		//return node.key, node.value, nil
@@ -1,28 +1,107 @@
 package graviton
 
+import "fmt"
 import "hash"
-
-//import "crypto/sha256"
+import "sync"
+import "crypto/sha256"
 import "golang.org/x/crypto/blake2s"
 
 const lastBit = HASHSIZE*8 - 1
 
-var zerosHash, zeros [HASHSIZE]byte // all empty nodes have this hash
+var zeros [HASHSIZE]byte
+
+// names of hash functions, blake3 is not built in and must be registered by the application with a 32 byte implementation
+const (
+	HASH_BLAKE2S = "blake2s" // default
+	HASH_SHA256  = "sha256"
+	HASH_BLAKE3  = "blake3"
+)
 
-func hasher() hash.Hash {
-	//return sha256.New()
-	h, _ := blake2s.New256(nil)
-	return h
+// HashFunction is used to hash keys, values and nodes of a store, a tree hash ( and thus any proof ) is only valid
+// for the hash function it was built with
+type HashFunction struct {
+	Name      string
+	new       func() hash.Hash
+	fastsum   func([]byte) [HASHSIZE]byte // allocation free sum, only for built in functions
+	zerosHash [HASHSIZE]byte              // all empty nodes have this hash
 }
 
-func sum(key []byte) (keyhash [HASHSIZE]byte) {
-	return blake2s.Sum256(key)
+var hash_functions = map[string]*HashFunction{}
+var hash_functions_lock sync.RWMutex
+
+// DefaultHash is used by stores which do not choose a hash function
+var DefaultHash = must_register(HASH_BLAKE2S, func() hash.Hash { h, _ := blake2s.New256(nil); return h }, blake2s.Sum256)
+
+var _ = must_register(HASH_SHA256, sha256.New, sha256.Sum256)
+
+func must_register(name string, new func() hash.Hash, fastsum func([]byte) [HASHSIZE]byte) *HashFunction {
+	hf, err := RegisterHash(name, new)
+	if err != nil {
+		panic(err)
+	}
+	hf.fastsum = fastsum
+	return hf
 }
 
-func init() {
-	h := hasher()
+// RegisterHash makes a hash function available to stores and proofs, the hash must produce HASHSIZE bytes
+// a name can only be registered once, registering a built in or already registered name is an error
+func RegisterHash(name string, new func() hash.Hash) (*HashFunction, error) {
+	if name == "" || new == nil {
+		return nil, fmt.Errorf("hash function name and implementation are required")
+	}
+	if size := new().Size(); size != HASHSIZE {
+		return nil, fmt.Errorf("hash function %s produces %d bytes, %d bytes are required", name, size, HASHSIZE)
+	}
+
+	hf := &HashFunction{Name: name, new: new}
+	h := hf.hasher()
 	h.Write([]byte{leafNODE})
 	h.Write(zeros[:])
-	tmp := zerosHash[:0]
-	h.Sum(tmp)
+	h.Sum(hf.zerosHash[:0])
+
+	hash_functions_lock.Lock()
+	defer hash_functions_lock.Unlock()
+	if _, ok := hash_functions[name]; ok {
+		return nil, fmt.Errorf("hash function %s is already registered", name)
+	}
+	hash_functions[name] = hf
+	return hf, nil
+}
+
+// GetHash returns a registered hash function, empty name returns the default hash function
+func GetHash(name string) (*HashFunction, error) {
+	if name == "" {
+		return DefaultHash, nil
+	}
+	hash_functions_lock.RLock()
+	defer hash_functions_lock.RUnlock()
+	if hf, ok := hash_functions[name]; ok {
+		return hf, nil
+	}
+	return nil, fmt.Errorf("hash function %s is not registered", name)
+}
+
+func (hf *HashFunction) hasher() hash.Hash {
+	return hf.new()
+}
+
+func (hf *HashFunction) sum(key []byte) (keyhash [HASHSIZE]byte) {
+	if hf.fastsum != nil {
+		return hf.fastsum(key)
+	}
+	h := hf.new()
+	h.Write(key)
+	h.Sum(keyhash[:0])
+	return
+}
+
+// leaf hash is hash of key hash and value hash
+func (hf *HashFunction) leafHash(hkey, hvalue []byte) []byte {
+	rst := make([]byte, 0, HASHSIZE)
+	h := hf.hasher()
+	h.Write([]byte{leafNODE})
+	h.Write(hkey)
+	h.Write(hvalue)
+	rst = h.Sum(rst)
+	return rst
 }
@@ -0,0 +1,107 @@
+package graviton
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func setupHashTree(t *testing.T, store *Store) *Tree {
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		require.NoError(t, tree.Put([]byte(key), []byte("value"+key)))
+	}
+	require.NoError(t, tree.Commit())
+	return tree
+}
+
+func TestHashFunctions(t *testing.T) {
+	_, err := RegisterHash("md5", md5.New) // wrong size
+	require.Error(t, err)
+	_, err = NewMemStore(StoreOptions{Hash: HASH_BLAKE3}) // not registered
+	require.Error(t, err)
+	if _, err = GetHash("sha512_256"); err != nil { // test may run more than once
+		_, err = RegisterHash("sha512_256", sha512.New512_256)
+		require.NoError(t, err)
+	}
+	for _, name := range []string{HASH_BLAKE2S, HASH_SHA256, "sha512_256"} { // names cannot be replaced
+		_, err = RegisterHash(name, sha512.New512_256)
+		require.Error(t, err)
+	}
+
+	roots := map[string][HASHSIZE]byte{}
+	for _, name := range []string{HASH_BLAKE2S, HASH_SHA256, "sha512_256"} {
+		store, err := NewMemStore(StoreOptions{Hash: name})
+		require.NoError(t, err)
+		tree := setupHashTree(t, store)
+		root := tree.hashSkipError()
+		for _, other := range roots {
+			require.NotEqual(t, other, root)
+		}
+		roots[name] = root
+
+		proof, err := tree.GenerateProof([]byte("key1"))
+		require.NoError(t, err)
+		var decoded Proof
+		require.NoError(t, decoded.Unmarshal(proof.Marshal()))
+		require.True(t, decoded.VerifyMembershipUsing(name, root, []byte("key1")))
+		require.Equal(t, name == HASH_BLAKE2S, decoded.VerifyMembership(root, []byte("key1")))
+		require.False(t, decoded.VerifyMembershipUsing(HASH_BLAKE3, root, []byte("key1")))
+
+		proof, err = tree.GenerateProof([]byte("missingkey"))
+		require.NoError(t, err)
+		require.NoError(t, decoded.Unmarshal(proof.Marshal()))
+		require.True(t, decoded.VerifyNonMembershipUsing(name, root, []byte("missingkey")))
+		require.False(t, decoded.VerifyMembershipUsing(name, root, []byte("missingkey")))
+	}
+
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	require.Equal(t, roots[HASH_BLAKE2S], setupHashTree(t, store).hashSkipError()) // default
+}
+
+func TestHashHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_hash")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	base := filepath.Join(dir, "store")
+	store, err := NewDiskStore(base, StoreOptions{Hash: HASH_SHA256})
+	require.NoError(t, err)
+	root := setupHashTree(t, store).hashSkipError()
+
+	var buf bytes.Buffer
+	require.NoError(t, store.Backup(&buf, 0))
+	store.Close()
+
+	_, err = NewDiskStore(base, StoreOptions{Hash: HASH_BLAKE2S})
+	require.True(t, xerrors.Is(err, ErrHashMismatch))
+
+	store, err = NewDiskStore(base) // hash function is taken from header
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	require.Equal(t, root, tree.hashSkipError())
+	value, err := tree.Get([]byte("key2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("valuekey2"), value)
+	store.Close()
+
+	// restored store uses same hash function
+	restored, err := RestoreStore(bytes.NewReader(buf.Bytes()), filepath.Join(dir, "restored"))
+	require.NoError(t, err)
+	require.Equal(t, HASH_SHA256, restored.hash.Name)
+	restored.Close()
+}
@@ -0,0 +1,173 @@
+package graviton
+
+import "os"
+import "fmt"
+import "bytes"
+import "bufio"
+import "strconv"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+
+import "golang.org/x/xerrors"
+
+// store header records the format version and parameters chosen at store creation, it is checked every time a store
+// is opened so as a store is never read with wrong parameters. It is a small text, one "name value" pair per line,
+// unknown names are ignored. Incompatible changes bump the format version. Disk stores keep it in a file, other
+// backends keep it using Backend.WriteHeader, for example object stores as a reserved object.
+const header_file = "graviton_header"
+const header_magic = "graviton store"
+
+// format of stores written by this release. stores with a lower version are read as is and their header is written
+// in current format on first commit, MigrateStore can also rewrite them completely
+//
+//	0: no header or header without format version, data layout is same as 1
+//	1: header records format, hash function and max file size
+//
+// Additions which older readers ignore do not change the format, such as leaf counts appended to inner nodes.
+const STORE_FORMAT_VERSION = 1
+
+// stores with an older format have a different data layout and must be migrated using an older release
+const oldest_readable_format = 0
+
+type storeHeader struct {
+	format        int
+	hash          string // name of hash function
+	max_file_size int64
+}
+
+func read_header(path string) (hdr storeHeader, err error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	return parse_header(path, buf)
+}
+
+func parse_header(name string, buf []byte) (hdr storeHeader, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	if !scanner.Scan() || scanner.Text() != header_magic {
+		return hdr, fmt.Errorf("%s is not a store header", name)
+	}
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			return hdr, fmt.Errorf("invalid line in store header %s", name)
+		}
+		switch fields[0] {
+		case "format":
+			hdr.format, err = strconv.Atoi(fields[1])
+		case "hash":
+			hdr.hash = fields[1]
+		case "max_file_size":
+			hdr.max_file_size, err = strconv.ParseInt(fields[1], 10, 64)
+		}
+		if err != nil {
+			return hdr, fmt.Errorf("invalid %s in store header %s: %s", fields[0], name, err)
+		}
+	}
+	if err = scanner.Err(); err == nil && hdr.hash == "" {
+		err = fmt.Errorf("store header %s does not record hash function", name)
+	}
+	return
+}
+
+func (hdr storeHeader) serialize() []byte {
+	return []byte(fmt.Sprintf("%s\nformat %d\nhash %s\nmax_file_size %d\n", header_magic, hdr.format, hdr.hash, hdr.max_file_size))
+}
+
+func write_header(path string, hdr storeHeader) error {
+	return write_file_synced(path, hdr.serialize())
+}
+
+// file is written to a temporary file and renamed, so as it is never seen partially written
+func write_file_synced(path string, buf []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncdir(filepath.Dir(path))
+}
+
+// whether a directory holds data of a store
+func has_store_data(dir string) bool {
+	for _, name := range []string{"version_root.bin", filepath.FromSlash(chunk_name(0))} {
+		if finfo, err := os.Stat(filepath.Join(dir, name)); err == nil && finfo.Size() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// check header of a store and find its hash function, new stores record the requested parameters
+// disk stores of an older format get their header upgraded on first commit
+func (s *Store) check_header() (*HashFunction, error) {
+	name := "store"
+	if s.disk != nil {
+		name = s.disk.dir
+	}
+
+	var hdr storeHeader
+	buf, err := s.backend.ReadHeader()
+	exists := err == nil && len(buf) > 0
+	if exists {
+		hdr, err = parse_header(name, buf)
+	} else if err == nil {
+		hdr = storeHeader{format: STORE_FORMAT_VERSION, hash: s.options.Hash, max_file_size: MAX_FILE_SIZE}
+		if s.disk != nil && has_store_data(s.disk.dir) {
+			hdr.format, hdr.hash, exists = 0, HASH_BLAKE2S, true // stores without header always used blake2s
+		}
+		if hdr.hash == "" {
+			hdr.hash = DefaultHash.Name
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hdr.format < 1 { // max file size has never changed
+		hdr.max_file_size = MAX_FILE_SIZE
+	}
+
+	if hdr.format < oldest_readable_format {
+		return nil, xerrors.Errorf("%w: %s has format version %d, oldest readable version is %d", ErrMigrationRequired, name, hdr.format, oldest_readable_format)
+	}
+	if hdr.format > STORE_FORMAT_VERSION {
+		return nil, xerrors.Errorf("%w: %s has format version %d, highest supported version is %d", ErrUnsupportedFormat, name, hdr.format, STORE_FORMAT_VERSION)
+	}
+	if hdr.max_file_size != MAX_FILE_SIZE {
+		return nil, xerrors.Errorf("%w: %s uses max file size %d, this release uses %d", ErrUnsupportedFormat, name, hdr.max_file_size, MAX_FILE_SIZE)
+	}
+	if s.options.Hash != "" && s.options.Hash != hdr.hash {
+		return nil, xerrors.Errorf("%w: store uses %s, requested %s", ErrHashMismatch, hdr.hash, s.options.Hash)
+	}
+
+	s.format = hdr.format
+	s.header_upgrade = exists && hdr.format < STORE_FORMAT_VERSION && !s.migrating
+	hf, err := GetHash(hdr.hash)
+	if err == nil && !exists {
+		err = s.backend.WriteHeader(hdr.serialize())
+	}
+	return hf, err
+}
+
+// write header of an older format store in current format, before anything is committed to it
+func (s *Store) upgrade_header() error {
+	hdr := storeHeader{format: STORE_FORMAT_VERSION, hash: s.hash.Name, max_file_size: MAX_FILE_SIZE}
+	if err := s.backend.WriteHeader(hdr.serialize()); err != nil {
+		return err
+	}
+	s.format, s.header_upgrade = STORE_FORMAT_VERSION, false
+	return nil
+}
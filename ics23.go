@@ -0,0 +1,119 @@
+package graviton
+
+import "fmt"
+
+import "github.com/deroproject/graviton/ics23"
+
+// ICS23 proofs are built from regular graviton proofs, so they prove exactly same facts. A member proof becomes an
+// existence proof, collision and deadend proofs become non existence proofs carrying existence proofs of the
+// neighbors of the key in hash order. ICS23 does not allow empty values, so keys with empty values cannot be proved.
+
+var ics23_hash_ops = map[string]ics23.HashOp{
+	HASH_BLAKE2S: ics23.HashOp_BLAKE2S_256,
+	HASH_SHA256:  ics23.HashOp_SHA256,
+}
+
+// ICS23Spec returns the ICS23 proof spec of trees built with the named hash function
+func ICS23Spec(hashname string) (*ics23.ProofSpec, error) {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return nil, err
+	}
+	op, ok := ics23_hash_ops[hf.Name]
+	if !ok {
+		return nil, fmt.Errorf("hash function %s is not supported by ICS23", hf.Name)
+	}
+	return ics23.GravitonSpec(op)
+}
+
+// GenerateICS23Proof generates an ICS23 proof of existence or non-existence of the key, the proof is verified
+// against tree root hash using the spec returned by ICS23Spec
+func (t *Tree) GenerateICS23Proof(key []byte) (*ics23.CommitmentProof, error) {
+	op, ok := ics23_hash_ops[t.store.hash.Name]
+	if !ok {
+		return nil, fmt.Errorf("hash function %s is not supported by ICS23", t.store.hash.Name)
+	}
+	proof, err := t.GenerateProof(key)
+	if err != nil {
+		return nil, err
+	}
+	if proof.ptype() == member {
+		exist, err := t.ics23_existence(op, key, proof)
+		if err != nil {
+			return nil, err
+		}
+		return &ics23.CommitmentProof{Exist: exist}, nil
+	}
+
+	// key is missing, so rank points to the right neighbor
+	rank, err := t.Rank(key)
+	if err != nil {
+		return nil, err
+	}
+	count, err := t.Count()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("empty tree has no keys to prove non existence of %x", key)
+	}
+	nonexist := &ics23.NonExistenceProof{Key: key}
+	if rank > 0 {
+		if nonexist.Left, err = t.ics23_neighbor(op, rank-1); err != nil {
+			return nil, err
+		}
+	}
+	if rank < count {
+		if nonexist.Right, err = t.ics23_neighbor(op, rank); err != nil {
+			return nil, err
+		}
+	}
+	return &ics23.CommitmentProof{Nonexist: nonexist}, nil
+}
+
+// existence proof of key at position n in hash order
+func (t *Tree) ics23_neighbor(op ics23.HashOp, n uint64) (*ics23.ExistenceProof, error) {
+	key, _, err := t.KeyAt(n)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := t.GenerateProof(key)
+	if err != nil {
+		return nil, err
+	}
+	if proof.ptype() != member {
+		return nil, fmt.Errorf("neighbor %x could not be proved", key)
+	}
+	return t.ics23_existence(op, key, proof)
+}
+
+// convert member proof to existence proof, path goes from leaf to root
+func (t *Tree) ics23_existence(op ics23.HashOp, key []byte, proof *Proof) (*ics23.ExistenceProof, error) {
+	value, trace := proof.Value(), proof.p.Trace()
+	if len(value) == 0 {
+		return nil, fmt.Errorf("key %x has empty value which cannot be proved by ICS23", key)
+	}
+	hf := t.store.hash
+	keyhash := hf.sum(key)
+	exist := &ics23.ExistenceProof{
+		Key:   append([]byte{}, key...),
+		Value: value,
+		Leaf:  &ics23.LeafOp{Hash: op, PrehashKey: op, PrehashValue: op, Length: ics23.LengthOp_NO_PREFIX, Prefix: []byte{leafNODE}},
+		Path:  make([]*ics23.InnerOp, 0, len(trace)),
+	}
+	for i := len(trace) - 1; i >= 0; i-- {
+		sibling := trace[i]
+		if sibling == nil {
+			sibling = hf.zerosHash[:]
+		}
+		step := &ics23.InnerOp{Hash: op}
+		if isBitSet(keyhash[:], uint(i)) {
+			step.Prefix = append([]byte{innerNODE}, sibling...)
+		} else {
+			step.Prefix = []byte{innerNODE}
+			step.Suffix = append([]byte{}, sibling...)
+		}
+		exist.Path = append(exist.Path, step)
+	}
+	return exist, nil
+}
@@ -0,0 +1,124 @@
+package ics23
+
+import "encoding/binary"
+
+// messages are serialized in the protobuf wire format of ICS23 messages ( cosmos/ics23 proofs.proto ), so the output
+// can be decoded by any ICS23 implementation, only fields used by graviton are emitted
+
+const (
+	wire_varint = 0
+	wire_bytes  = 2
+)
+
+func append_varint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func append_tag(buf []byte, field int, wiretype int) []byte {
+	return append_varint(buf, uint64(field<<3|wiretype))
+}
+
+// zero values are skipped as in proto3
+func append_int(buf []byte, field int, v int64) []byte {
+	if v == 0 {
+		return buf
+	}
+	return append_varint(append_tag(buf, field, wire_varint), uint64(v))
+}
+
+func append_bytes(buf []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return buf
+	}
+	return append(append_varint(append_tag(buf, field, wire_bytes), uint64(len(v))), v...)
+}
+
+// embedded messages are emitted even if empty
+func append_message(buf []byte, field int, v []byte) []byte {
+	return append(append_varint(append_tag(buf, field, wire_bytes), uint64(len(v))), v...)
+}
+
+// Marshal serializes the leaf operation as ICS23 LeafOp message
+func (op *LeafOp) Marshal() (buf []byte) {
+	buf = append_int(buf, 1, int64(op.Hash))
+	buf = append_int(buf, 2, int64(op.PrehashKey))
+	buf = append_int(buf, 3, int64(op.PrehashValue))
+	buf = append_int(buf, 4, int64(op.Length))
+	return append_bytes(buf, 5, op.Prefix)
+}
+
+// Marshal serializes the inner operation as ICS23 InnerOp message
+func (op *InnerOp) Marshal() (buf []byte) {
+	buf = append_int(buf, 1, int64(op.Hash))
+	buf = append_bytes(buf, 2, op.Prefix)
+	return append_bytes(buf, 3, op.Suffix)
+}
+
+// Marshal serializes the proof as ICS23 ExistenceProof message
+func (p *ExistenceProof) Marshal() (buf []byte) {
+	buf = append_bytes(buf, 1, p.Key)
+	buf = append_bytes(buf, 2, p.Value)
+	if p.Leaf != nil {
+		buf = append_message(buf, 3, p.Leaf.Marshal())
+	}
+	for _, step := range p.Path {
+		buf = append_message(buf, 4, step.Marshal())
+	}
+	return buf
+}
+
+// Marshal serializes the proof as ICS23 NonExistenceProof message
+func (p *NonExistenceProof) Marshal() (buf []byte) {
+	buf = append_bytes(buf, 1, p.Key)
+	if p.Left != nil {
+		buf = append_message(buf, 2, p.Left.Marshal())
+	}
+	if p.Right != nil {
+		buf = append_message(buf, 3, p.Right.Marshal())
+	}
+	return buf
+}
+
+// Marshal serializes the proof as ICS23 CommitmentProof message
+func (p *CommitmentProof) Marshal() (buf []byte) {
+	if p.Exist != nil {
+		buf = append_message(buf, 1, p.Exist.Marshal())
+	}
+	if p.Nonexist != nil {
+		buf = append_message(buf, 2, p.Nonexist.Marshal())
+	}
+	return buf
+}
+
+// Marshal serializes the spec as ICS23 InnerSpec message
+func (spec *InnerSpec) Marshal() (buf []byte) {
+	if len(spec.ChildOrder) > 0 { // packed repeated field
+		var order []byte
+		for _, branch := range spec.ChildOrder {
+			order = append_varint(order, uint64(branch))
+		}
+		buf = append_message(buf, 1, order)
+	}
+	buf = append_int(buf, 2, int64(spec.ChildSize))
+	buf = append_int(buf, 3, int64(spec.MinPrefixLength))
+	buf = append_int(buf, 4, int64(spec.MaxPrefixLength))
+	buf = append_bytes(buf, 5, spec.EmptyChild)
+	return append_int(buf, 6, int64(spec.Hash))
+}
+
+// Marshal serializes the spec as ICS23 ProofSpec message
+func (spec *ProofSpec) Marshal() (buf []byte) {
+	if spec.LeafSpec != nil {
+		buf = append_message(buf, 1, spec.LeafSpec.Marshal())
+	}
+	if spec.InnerSpec != nil {
+		buf = append_message(buf, 2, spec.InnerSpec.Marshal())
+	}
+	buf = append_int(buf, 3, int64(spec.MaxDepth))
+	buf = append_int(buf, 4, int64(spec.MinDepth))
+	if spec.PrehashKeyBeforeComparison {
+		buf = append_int(buf, 5, 1)
+	}
+	return buf
+}
@@ -0,0 +1,432 @@
+// Copyright dero developers
+
+/*
+Package ics23 describes graviton proofs in the ICS23 format used by IBC style ecosystems. It mirrors the ICS23
+ProofSpec, ExistenceProof and NonExistenceProof messages, verifies them following ICS23 rules and serializes them in
+the ICS23 protobuf wire format, without depending on protobuf libraries.
+
+Graviton is a binary tree, a leaf hash is hash(0x02 || hash(key) || hash(value)) and an inner hash is
+hash(0x01 || left || right). Empty children have a fixed hash, which depends on the hash function. Keys are ordered
+by their hash, so specs require keys to be hashed before comparison. Proofs are generated by graviton using
+tree.GenerateICS23Proof.
+*/
+package ics23
+
+import "fmt"
+import "hash"
+import "bytes"
+import "crypto/sha256"
+import "crypto/sha512"
+
+import "golang.org/x/crypto/blake2s"
+
+// HashOp is the hash function applied by an operation, values are same as ICS23
+type HashOp int32
+
+const (
+	HashOp_NO_HASH     HashOp = 0
+	HashOp_SHA256      HashOp = 1
+	HashOp_SHA512      HashOp = 2
+	HashOp_BLAKE2S_256 HashOp = 8
+)
+
+// LengthOp is the length prefix applied to leaf data, graviton only uses NO_PREFIX
+type LengthOp int32
+
+const LengthOp_NO_PREFIX LengthOp = 0
+
+// LeafOp describes how a leaf hash is calculated from key and value
+type LeafOp struct {
+	Hash         HashOp
+	PrehashKey   HashOp
+	PrehashValue HashOp
+	Length       LengthOp
+	Prefix       []byte
+}
+
+// InnerOp calculates hash of an inner node as hash(Prefix || child || Suffix)
+type InnerOp struct {
+	Hash   HashOp
+	Prefix []byte
+	Suffix []byte
+}
+
+// ExistenceProof proves Key has Value, Path is from leaf to root
+type ExistenceProof struct {
+	Key   []byte
+	Value []byte
+	Leaf  *LeafOp
+	Path  []*InnerOp
+}
+
+// NonExistenceProof proves Key does not exist using its neighbors, atleast one of them must exist
+type NonExistenceProof struct {
+	Key   []byte
+	Left  *ExistenceProof
+	Right *ExistenceProof
+}
+
+// CommitmentProof carries either an existence or a non existence proof
+type CommitmentProof struct {
+	Exist    *ExistenceProof
+	Nonexist *NonExistenceProof
+}
+
+// InnerSpec describes inner nodes of a tree
+type InnerSpec struct {
+	ChildOrder      []int32
+	ChildSize       int32
+	MinPrefixLength int32
+	MaxPrefixLength int32
+	EmptyChild      []byte
+	Hash            HashOp
+}
+
+// ProofSpec describes a tree, proofs are only accepted if they match the spec
+type ProofSpec struct {
+	LeafSpec                   *LeafOp
+	InnerSpec                  *InnerSpec
+	MaxDepth                   int32
+	MinDepth                   int32
+	PrehashKeyBeforeComparison bool
+}
+
+// graviton node types
+const (
+	innerNODE byte = 1
+	leafNODE  byte = 2
+)
+
+// specs of graviton trees using built in hash functions
+var (
+	GravitonBlake2sSpec, _ = GravitonSpec(HashOp_BLAKE2S_256)
+	GravitonSHA256Spec, _  = GravitonSpec(HashOp_SHA256)
+)
+
+// GravitonSpec returns the spec of graviton trees built with the hash function, an error is returned if the hash
+// function is not supported
+func GravitonSpec(op HashOp) (*ProofSpec, error) {
+	empty, err := doHash(op, append([]byte{leafNODE}, make([]byte, 32)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(empty) != 32 { // graviton hashes are always 32 bytes
+		return nil, fmt.Errorf("hash op %d does not produce 32 byte hashes", op)
+	}
+	return &ProofSpec{
+		LeafSpec: &LeafOp{Hash: op, PrehashKey: op, PrehashValue: op, Length: LengthOp_NO_PREFIX, Prefix: []byte{leafNODE}},
+		InnerSpec: &InnerSpec{
+			ChildOrder:      []int32{0, 1},
+			ChildSize:       32,
+			MinPrefixLength: 1,
+			MaxPrefixLength: 1,
+			EmptyChild:      empty,
+			Hash:            op,
+		},
+		MaxDepth:                   256,
+		PrehashKeyBeforeComparison: true,
+	}, nil
+}
+
+func doHash(op HashOp, data []byte) ([]byte, error) {
+	var h hash.Hash
+	switch op {
+	case HashOp_NO_HASH:
+		return data, nil
+	case HashOp_SHA256:
+		h = sha256.New()
+	case HashOp_SHA512:
+		h = sha512.New()
+	case HashOp_BLAKE2S_256:
+		h, _ = blake2s.New256(nil)
+	default:
+		return nil, fmt.Errorf("unsupported hash op %d", op)
+	}
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// Apply calculates the leaf hash
+func (op *LeafOp) Apply(key, value []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("leaf op needs key")
+	}
+	if len(value) == 0 {
+		return nil, fmt.Errorf("leaf op needs value")
+	}
+	if op.Length != LengthOp_NO_PREFIX {
+		return nil, fmt.Errorf("unsupported length op %d", op.Length)
+	}
+	pkey, err := doHash(op.PrehashKey, key)
+	if err != nil {
+		return nil, err
+	}
+	pvalue, err := doHash(op.PrehashValue, value)
+	if err != nil {
+		return nil, err
+	}
+	return doHash(op.Hash, append(append(append([]byte{}, op.Prefix...), pkey...), pvalue...))
+}
+
+// Apply calculates hash of inner node from hash of child
+func (op *InnerOp) Apply(child []byte) ([]byte, error) {
+	if len(child) == 0 {
+		return nil, fmt.Errorf("inner op needs child value")
+	}
+	return doHash(op.Hash, append(append(append([]byte{}, op.Prefix...), child...), op.Suffix...))
+}
+
+// Calculate the root hash of the proof
+func (p *ExistenceProof) Calculate() ([]byte, error) {
+	if p.Leaf == nil {
+		return nil, fmt.Errorf("existence proof must start with a leaf operation")
+	}
+	res, err := p.Leaf.Apply(p.Key, p.Value)
+	for _, step := range p.Path {
+		if err != nil {
+			return nil, err
+		}
+		res, err = step.Apply(res)
+	}
+	return res, err
+}
+
+// CheckAgainstSpec checks that proof can be produced by a tree described by spec
+func (p *ExistenceProof) CheckAgainstSpec(spec *ProofSpec) error {
+	if p.Leaf == nil {
+		return fmt.Errorf("existence proof must start with a leaf operation")
+	}
+	lspec := spec.LeafSpec
+	if p.Leaf.Hash != lspec.Hash || p.Leaf.PrehashKey != lspec.PrehashKey || p.Leaf.PrehashValue != lspec.PrehashValue || p.Leaf.Length != lspec.Length {
+		return fmt.Errorf("leaf operation does not match spec")
+	}
+	if !bytes.HasPrefix(p.Leaf.Prefix, lspec.Prefix) {
+		return fmt.Errorf("leaf prefix does not start with %x", lspec.Prefix)
+	}
+	if spec.MinDepth > 0 && len(p.Path) < int(spec.MinDepth) {
+		return fmt.Errorf("proof has %d steps, spec requires atleast %d", len(p.Path), spec.MinDepth)
+	}
+	if spec.MaxDepth > 0 && len(p.Path) > int(spec.MaxDepth) {
+		return fmt.Errorf("proof has %d steps, spec allows atmost %d", len(p.Path), spec.MaxDepth)
+	}
+
+	ispec := spec.InnerSpec
+	maxLeftChildBytes := (len(ispec.ChildOrder) - 1) * int(ispec.ChildSize)
+	for i, step := range p.Path {
+		if step.Hash != ispec.Hash {
+			return fmt.Errorf("inner operation %d hash does not match spec", i)
+		}
+		if bytes.HasPrefix(step.Prefix, lspec.Prefix) {
+			return fmt.Errorf("inner operation %d prefix starts with leaf prefix", i)
+		}
+		if len(step.Prefix) < int(ispec.MinPrefixLength) || len(step.Prefix) > int(ispec.MaxPrefixLength)+maxLeftChildBytes {
+			return fmt.Errorf("inner operation %d has invalid prefix length %d", i, len(step.Prefix))
+		}
+		if len(step.Suffix)%int(ispec.ChildSize) != 0 {
+			return fmt.Errorf("inner operation %d has invalid suffix length %d", i, len(step.Suffix))
+		}
+	}
+	return nil
+}
+
+// Verify that proof matches spec, root, key and value
+func (p *ExistenceProof) Verify(spec *ProofSpec, root, key, value []byte) error {
+	if !bytes.Equal(p.Key, key) {
+		return fmt.Errorf("proof is for key %x, not %x", p.Key, key)
+	}
+	if !bytes.Equal(p.Value, value) {
+		return fmt.Errorf("proof value does not match")
+	}
+	if err := p.CheckAgainstSpec(spec); err != nil {
+		return err
+	}
+	calculated, err := p.Calculate()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(calculated, root) {
+		return fmt.Errorf("calculated root %x does not match %x", calculated, root)
+	}
+	return nil
+}
+
+func keyForComparison(spec *ProofSpec, key []byte) []byte {
+	if !spec.PrehashKeyBeforeComparison {
+		return key
+	}
+	hashed, err := doHash(spec.LeafSpec.PrehashKey, key)
+	if err != nil {
+		return key
+	}
+	return hashed
+}
+
+// Verify that neighbors of key exist and are adjacent, so as key cannot exist
+func (p *NonExistenceProof) Verify(spec *ProofSpec, root, key []byte) error {
+	if p.Left == nil && p.Right == nil {
+		return fmt.Errorf("both left and right neighbors are missing")
+	}
+	if p.Left != nil {
+		if err := p.Left.Verify(spec, root, p.Left.Key, p.Left.Value); err != nil {
+			return fmt.Errorf("left neighbor: %w", err)
+		}
+		if bytes.Compare(keyForComparison(spec, p.Left.Key), keyForComparison(spec, key)) >= 0 {
+			return fmt.Errorf("left neighbor is not before key")
+		}
+	}
+	if p.Right != nil {
+		if err := p.Right.Verify(spec, root, p.Right.Key, p.Right.Value); err != nil {
+			return fmt.Errorf("right neighbor: %w", err)
+		}
+		if bytes.Compare(keyForComparison(spec, key), keyForComparison(spec, p.Right.Key)) >= 0 {
+			return fmt.Errorf("right neighbor is not after key")
+		}
+	}
+
+	switch {
+	case p.Left == nil:
+		if !isLeftMost(spec.InnerSpec, p.Right.Path) {
+			return fmt.Errorf("right neighbor is not left most")
+		}
+	case p.Right == nil:
+		if !isRightMost(spec.InnerSpec, p.Left.Path) {
+			return fmt.Errorf("left neighbor is not right most")
+		}
+	default:
+		if !isLeftNeighbor(spec.InnerSpec, p.Left.Path, p.Right.Path) {
+			return fmt.Errorf("neighbors are not adjacent")
+		}
+	}
+	return nil
+}
+
+// VerifyMembership checks that proof proves key has value in tree with root
+func VerifyMembership(spec *ProofSpec, root []byte, proof *CommitmentProof, key, value []byte) bool {
+	if proof == nil || proof.Exist == nil {
+		return false
+	}
+	return proof.Exist.Verify(spec, root, key, value) == nil
+}
+
+// VerifyNonMembership checks that proof proves key does not exist in tree with root
+func VerifyNonMembership(spec *ProofSpec, root []byte, proof *CommitmentProof, key []byte) bool {
+	if proof == nil || proof.Nonexist == nil || !bytes.Equal(proof.Nonexist.Key, key) {
+		return false
+	}
+	return proof.Nonexist.Verify(spec, root, key) == nil
+}
+
+// padding of a step which takes branch, branch is position in child order
+func getPadding(spec *InnerSpec, branch int32) (minPrefix, maxPrefix, suffix int) {
+	idx := getPosition(spec.ChildOrder, branch)
+	prefix := idx * int(spec.ChildSize)
+	minPrefix = prefix + int(spec.MinPrefixLength)
+	maxPrefix = prefix + int(spec.MaxPrefixLength)
+	suffix = (len(spec.ChildOrder) - 1 - idx) * int(spec.ChildSize)
+	return
+}
+
+func getPosition(order []int32, branch int32) int {
+	for i, item := range order {
+		if item == branch {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasPadding(op *InnerOp, minPrefix, maxPrefix, suffix int) bool {
+	return len(op.Prefix) >= minPrefix && len(op.Prefix) <= maxPrefix && len(op.Suffix) == suffix
+}
+
+// branch taken by step, -1 if it matches no branch
+func orderFromPadding(spec *InnerSpec, op *InnerOp) int32 {
+	for branch := int32(0); branch < int32(len(spec.ChildOrder)); branch++ {
+		minPrefix, maxPrefix, suffix := getPadding(spec, branch)
+		if hasPadding(op, minPrefix, maxPrefix, suffix) {
+			return branch
+		}
+	}
+	return -1
+}
+
+func leftBranchesAreEmpty(spec *InnerSpec, op *InnerOp) bool {
+	idx := orderFromPadding(spec, op)
+	if idx <= 0 {
+		return false
+	}
+	size := int(spec.ChildSize)
+	actualPrefix := len(op.Prefix) - int(idx)*size
+	if actualPrefix < 0 {
+		return false
+	}
+	for i := int32(0); i < idx; i++ {
+		from := actualPrefix + getPosition(spec.ChildOrder, i)*size
+		if !bytes.Equal(spec.EmptyChild, op.Prefix[from:from+size]) {
+			return false
+		}
+	}
+	return true
+}
+
+func rightBranchesAreEmpty(spec *InnerSpec, op *InnerOp) bool {
+	idx := orderFromPadding(spec, op)
+	if idx < 0 {
+		return false
+	}
+	size := int(spec.ChildSize)
+	rightBranches := len(spec.ChildOrder) - 1 - int(idx)
+	if rightBranches == 0 || len(op.Suffix) != rightBranches*size {
+		return false
+	}
+	for i := 0; i < rightBranches; i++ {
+		from := i * size
+		if !bytes.Equal(spec.EmptyChild, op.Suffix[from:from+size]) {
+			return false
+		}
+	}
+	return true
+}
+
+// every step takes left most branch or all branches on its left are empty
+func isLeftMost(spec *InnerSpec, path []*InnerOp) bool {
+	minPrefix, maxPrefix, suffix := getPadding(spec, 0)
+	for _, step := range path {
+		if !hasPadding(step, minPrefix, maxPrefix, suffix) && !leftBranchesAreEmpty(spec, step) {
+			return false
+		}
+	}
+	return true
+}
+
+// every step takes right most branch or all branches on its right are empty
+func isRightMost(spec *InnerSpec, path []*InnerOp) bool {
+	minPrefix, maxPrefix, suffix := getPadding(spec, int32(len(spec.ChildOrder)-1))
+	for _, step := range path {
+		if !hasPadding(step, minPrefix, maxPrefix, suffix) && !rightBranchesAreEmpty(spec, step) {
+			return false
+		}
+	}
+	return true
+}
+
+// paths share all steps above the node where they split, at the split left takes the branch just before right,
+// below it left path is right most and right path is left most
+func isLeftNeighbor(spec *InnerSpec, left, right []*InnerOp) bool {
+	for len(left) > 0 && len(right) > 0 {
+		topleft, topright := left[len(left)-1], right[len(right)-1]
+		if !bytes.Equal(topleft.Prefix, topright.Prefix) || !bytes.Equal(topleft.Suffix, topright.Suffix) {
+			break
+		}
+		left, right = left[:len(left)-1], right[:len(right)-1]
+	}
+	if len(left) == 0 || len(right) == 0 {
+		return false
+	}
+	topleft, topright := left[len(left)-1], right[len(right)-1]
+	leftidx, rightidx := orderFromPadding(spec, topleft), orderFromPadding(spec, topright)
+	if leftidx < 0 || rightidx != leftidx+1 {
+		return false
+	}
+	return isRightMost(spec, left[:len(left)-1]) && isLeftMost(spec, right[:len(right)-1])
+}
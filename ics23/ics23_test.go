@@ -0,0 +1,102 @@
+package ics23
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sha(parts ...[]byte) []byte {
+	h := sha256.Sum256(bytes.Join(parts, nil))
+	return h[:]
+}
+
+// two keys tree, built by hand
+func TestVerify(t *testing.T) {
+	spec := GravitonSHA256Spec
+	require.Equal(t, sha([]byte{leafNODE}, make([]byte, 32)), spec.InnerSpec.EmptyChild)
+
+	keys := [][]byte{[]byte("a"), []byte("b")}
+	if bytes.Compare(sha(keys[0]), sha(keys[1])) > 0 {
+		keys[0], keys[1] = keys[1], keys[0]
+	}
+	leaves := [][]byte{sha([]byte{leafNODE}, sha(keys[0]), sha([]byte("1"))), sha([]byte{leafNODE}, sha(keys[1]), sha([]byte("2")))}
+	root := sha([]byte{innerNODE}, leaves[0], leaves[1])
+
+	left := &ExistenceProof{Key: keys[0], Value: []byte("1"), Leaf: spec.LeafSpec,
+		Path: []*InnerOp{{Hash: HashOp_SHA256, Prefix: []byte{innerNODE}, Suffix: leaves[1]}}}
+	right := &ExistenceProof{Key: keys[1], Value: []byte("2"), Leaf: spec.LeafSpec,
+		Path: []*InnerOp{{Hash: HashOp_SHA256, Prefix: append([]byte{innerNODE}, leaves[0]...)}}}
+
+	require.True(t, VerifyMembership(spec, root, &CommitmentProof{Exist: left}, keys[0], []byte("1")))
+	require.True(t, VerifyMembership(spec, root, &CommitmentProof{Exist: right}, keys[1], []byte("2")))
+	require.False(t, VerifyMembership(spec, root, &CommitmentProof{Exist: right}, keys[1], []byte("1")))
+	require.False(t, VerifyMembership(GravitonBlake2sSpec, root, &CommitmentProof{Exist: right}, keys[1], []byte("2")))
+
+	require.True(t, isLeftMost(spec.InnerSpec, left.Path))
+	require.False(t, isLeftMost(spec.InnerSpec, right.Path))
+	require.True(t, isRightMost(spec.InnerSpec, right.Path))
+	require.True(t, isLeftNeighbor(spec.InnerSpec, left.Path, right.Path))
+	require.False(t, isLeftNeighbor(spec.InnerSpec, right.Path, left.Path))
+
+	// a key between both keys, and keys beyond them
+	for i := 0; i < 100; i++ {
+		key := []byte{0, byte(i)} // not a key of the tree
+		proof := &NonExistenceProof{Key: key}
+		switch hkey := sha(key); {
+		case bytes.Compare(hkey, sha(keys[0])) < 0:
+			proof.Right = right
+			require.False(t, VerifyNonMembership(spec, root, &CommitmentProof{Nonexist: proof}, key))
+			proof.Right = left
+		case bytes.Compare(hkey, sha(keys[1])) > 0:
+			proof.Left = left
+			require.False(t, VerifyNonMembership(spec, root, &CommitmentProof{Nonexist: proof}, key))
+			proof.Left = right
+		default:
+			proof.Left, proof.Right = left, right
+		}
+		require.NoError(t, proof.Verify(spec, root, key))
+		require.False(t, VerifyNonMembership(spec, root, &CommitmentProof{Nonexist: proof}, keys[0]))
+	}
+
+	// inner step disguised as a leaf
+	bad := &ExistenceProof{Key: keys[0], Value: []byte("1"), Leaf: spec.LeafSpec,
+		Path: []*InnerOp{{Hash: HashOp_SHA256, Prefix: []byte{leafNODE}, Suffix: leaves[1]}}}
+	require.Error(t, bad.CheckAgainstSpec(spec))
+	_, err := (&LeafOp{Hash: HashOp_SHA256}).Apply(keys[0], nil)
+	require.Error(t, err)
+}
+
+func TestGravitonSpec(t *testing.T) {
+	spec, err := GravitonSpec(HashOp_BLAKE2S_256)
+	require.NoError(t, err)
+	require.Equal(t, GravitonBlake2sSpec, spec)
+
+	// graviton hashes are 32 bytes
+	for _, op := range []HashOp{HashOp_NO_HASH, HashOp_SHA512, HashOp(99)} {
+		_, err := GravitonSpec(op)
+		require.Error(t, err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	proof := &CommitmentProof{Exist: &ExistenceProof{Key: []byte("k"), Value: []byte("v"), Leaf: &LeafOp{Hash: HashOp_SHA256, Prefix: []byte{2}},
+		Path: []*InnerOp{{Hash: HashOp_SHA256, Prefix: []byte{1}}}}}
+	expected := []byte{
+		0x0a, 0x14, // exist
+		0x0a, 0x01, 'k', 0x12, 0x01, 'v', // key, value
+		0x1a, 0x05, 0x08, 0x01, 0x2a, 0x01, 0x02, // leaf
+		0x22, 0x05, 0x08, 0x01, 0x12, 0x01, 0x01, // path
+	}
+	require.Equal(t, expected, proof.Marshal())
+
+	spec := &ProofSpec{InnerSpec: &InnerSpec{ChildOrder: []int32{0, 1}, ChildSize: 32}, MaxDepth: 256, PrehashKeyBeforeComparison: true}
+	expected = []byte{
+		0x12, 0x06, 0x0a, 0x02, 0x00, 0x01, 0x10, 0x20, // inner spec
+		0x18, 0x80, 0x02, // max depth
+		0x28, 0x01, // prehash key before comparison
+	}
+	require.Equal(t, expected, spec.Marshal())
+}
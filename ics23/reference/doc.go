@@ -0,0 +1,9 @@
+// Copyright dero developers
+
+/*
+Package reference checks graviton ICS23 proofs against the reference ICS23 implementation ( github.com/cosmos/ics23/go ).
+It is a separate module, so graviton itself does not depend on protobuf libraries. Proofs and specs are passed in
+their serialized form, so the protobuf encoding of package ics23 is checked as well. Run the checks using
+go test from this directory.
+*/
+package reference
@@ -0,0 +1,23 @@
+module github.com/deroproject/graviton/ics23/reference
+
+go 1.21
+
+require (
+	github.com/cosmos/ics23/go v0.11.0
+	github.com/deroproject/graviton v0.0.0
+	github.com/stretchr/testify v1.9.0
+)
+
+require (
+	github.com/cosmos/gogoproto v1.7.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/deroproject/graviton => ../..
@@ -0,0 +1,89 @@
+package reference
+
+import (
+	"fmt"
+	"testing"
+
+	ref "github.com/cosmos/ics23/go"
+	"github.com/deroproject/graviton"
+	"github.com/deroproject/graviton/ics23"
+	"github.com/stretchr/testify/require"
+)
+
+// decode the spec and proofs using the reference implementation
+func reference_spec(t *testing.T, spec *ics23.ProofSpec) *ref.ProofSpec {
+	var rspec ref.ProofSpec
+	require.NoError(t, rspec.Unmarshal(spec.Marshal()))
+	return &rspec
+}
+
+func reference_proof(t *testing.T, proof *ics23.CommitmentProof) *ref.CommitmentProof {
+	var rproof ref.CommitmentProof
+	require.NoError(t, rproof.Unmarshal(proof.Marshal()))
+	return &rproof
+}
+
+func TestReferenceVerify(t *testing.T) {
+	for _, hashname := range []string{graviton.HASH_BLAKE2S, graviton.HASH_SHA256} {
+		store, err := graviton.NewMemStore(graviton.StoreOptions{Hash: hashname})
+		require.NoError(t, err)
+		gv, err := store.LoadSnapshot(0)
+		require.NoError(t, err)
+		tree, err := gv.GetTree("root")
+		require.NoError(t, err)
+		for i := 0; i < 200; i++ {
+			require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+		}
+		require.NoError(t, tree.Commit())
+		root, err := tree.Hash()
+		require.NoError(t, err)
+
+		spec, err := graviton.ICS23Spec(hashname)
+		require.NoError(t, err)
+		rspec := reference_spec(t, spec)
+		require.Equal(t, spec.InnerSpec.EmptyChild, rspec.InnerSpec.EmptyChild)
+
+		for i := 0; i < 200; i++ {
+			key, value := []byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))
+			proof, err := tree.GenerateICS23Proof(key)
+			require.NoError(t, err)
+			rproof := reference_proof(t, proof)
+			require.True(t, ref.VerifyMembership(rspec, root[:], rproof, key, value), "key %s", key)
+			require.False(t, ref.VerifyMembership(rspec, root[:], rproof, key, []byte("other")))
+			require.False(t, ref.VerifyNonMembership(rspec, root[:], rproof, key))
+		}
+
+		// neighbors on both sides and keys beyond the first and last keys
+		var leftmost, rightmost, between bool
+		for i := 0; i < 5000 && !(leftmost && rightmost && between); i++ {
+			key := []byte(fmt.Sprintf("missing%d", i))
+			proof, err := tree.GenerateICS23Proof(key)
+			require.NoError(t, err)
+			rproof := reference_proof(t, proof)
+			require.True(t, ref.VerifyNonMembership(rspec, root[:], rproof, key), "key %s", key)
+			require.False(t, ref.VerifyNonMembership(rspec, root[:], rproof, []byte("key0")))
+			require.False(t, ref.VerifyMembership(rspec, root[:], rproof, key, []byte("value")))
+
+			switch {
+			case proof.Nonexist.Left == nil:
+				leftmost = true
+			case proof.Nonexist.Right == nil:
+				rightmost = true
+			default:
+				between = true
+
+				// both implementations must reject a proof missing a neighbor
+				proof.Nonexist.Left = nil
+				require.False(t, ics23.VerifyNonMembership(spec, root[:], proof, key))
+				require.False(t, ref.VerifyNonMembership(rspec, root[:], reference_proof(t, proof), key))
+			}
+		}
+		require.True(t, leftmost && rightmost && between)
+
+		// proofs are bound to the root
+		proof, err := tree.GenerateICS23Proof([]byte("key1"))
+		require.NoError(t, err)
+		root[0] ^= 1
+		require.False(t, ref.VerifyMembership(rspec, root[:], reference_proof(t, proof), []byte("key1"), []byte("value1")))
+	}
+}
@@ -0,0 +1,123 @@
+package graviton
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/deroproject/graviton/ics23"
+	"github.com/stretchr/testify/require"
+)
+
+func TestICS23Proof(t *testing.T) {
+	for _, hashname := range []string{HASH_BLAKE2S, HASH_SHA256} {
+		store, err := NewMemStore(StoreOptions{Hash: hashname})
+		require.NoError(t, err)
+		gv, err := store.LoadSnapshot(0)
+		require.NoError(t, err)
+		tree, err := gv.GetTree("root")
+		require.NoError(t, err)
+
+		_, err = tree.GenerateICS23Proof([]byte("key0"))
+		require.Error(t, err) // empty tree
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+		}
+		require.NoError(t, tree.Commit())
+		root := tree.hashSkipError()
+		spec, err := ICS23Spec(hashname)
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			key, value := []byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))
+			proof, err := tree.GenerateICS23Proof(key)
+			require.NoError(t, err)
+			require.True(t, ics23.VerifyMembership(spec, root[:], proof, key, value))
+			require.False(t, ics23.VerifyMembership(spec, root[:], proof, key, []byte("other")))
+			require.False(t, ics23.VerifyNonMembership(spec, root[:], proof, key))
+			require.NotEmpty(t, proof.Marshal())
+		}
+
+		// all kinds of graviton non membership proofs, including keys beyond the first and last keys
+		kinds := map[string]bool{}
+		for i := 0; i < 5000 && len(kinds) < 4; i++ {
+			key := []byte(fmt.Sprintf("missing%d", i))
+			gproof, err := tree.GenerateProof(key)
+			require.NoError(t, err)
+			proof, err := tree.GenerateICS23Proof(key)
+			require.NoError(t, err)
+			require.True(t, ics23.VerifyNonMembership(spec, root[:], proof, key), "key %s", key)
+			require.False(t, ics23.VerifyNonMembership(spec, root[:], proof, []byte("key0")))
+			require.False(t, ics23.VerifyMembership(spec, root[:], proof, key, []byte("value")))
+
+			kinds[proof_kinds[gproof.ptype()]] = true
+			if proof.Nonexist.Left == nil {
+				kinds["leftmost"] = true
+			}
+			if proof.Nonexist.Right == nil {
+				kinds["rightmost"] = true
+			}
+
+			// neighbors must be adjacent, skipping a key is detected
+			if proof.Nonexist.Left != nil && proof.Nonexist.Right != nil {
+				rank, err := tree.Rank(key)
+				require.NoError(t, err)
+				if rank >= 2 {
+					skipped, err := tree.ics23_neighbor(ics23_hash_ops[hashname], rank-2)
+					require.NoError(t, err)
+					tampered := &ics23.CommitmentProof{Nonexist: &ics23.NonExistenceProof{Key: key, Left: skipped, Right: proof.Nonexist.Right}}
+					require.False(t, ics23.VerifyNonMembership(spec, root[:], tampered, key))
+				}
+				tampered := &ics23.CommitmentProof{Nonexist: &ics23.NonExistenceProof{Key: key, Left: proof.Nonexist.Left}}
+				require.False(t, ics23.VerifyNonMembership(spec, root[:], tampered, key))
+			}
+		}
+		for _, kind := range []string{"collision", "deadend", "leftmost", "rightmost"} {
+			require.True(t, kinds[kind], "no non membership proof of kind %s", kind)
+		}
+
+		// proofs are bound to the root and the hash function
+		proof, err := tree.GenerateICS23Proof([]byte("key1"))
+		require.NoError(t, err)
+		root[0] ^= 1
+		require.False(t, ics23.VerifyMembership(spec, root[:], proof, []byte("key1"), []byte("value1")))
+		root[0] ^= 1
+		other := ics23.GravitonBlake2sSpec
+		if hashname == HASH_BLAKE2S {
+			other = ics23.GravitonSHA256Spec
+		}
+		require.False(t, ics23.VerifyMembership(other, root[:], proof, []byte("key1"), []byte("value1")))
+		proof.Exist.Path[0].Suffix, proof.Exist.Path[0].Prefix = proof.Exist.Path[0].Prefix[1:], []byte{innerNODE}
+		require.False(t, ics23.VerifyMembership(spec, root[:], proof, []byte("key1"), []byte("value1")))
+
+		// ICS23 does not allow empty values
+		require.NoError(t, tree.Put([]byte("empty"), nil))
+		_, err = tree.GenerateICS23Proof([]byte("empty"))
+		require.Error(t, err)
+	}
+
+	_, err := ICS23Spec("unknown")
+	require.Error(t, err)
+}
+
+func TestICS23SingleKey(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	require.NoError(t, tree.Put([]byte("key"), []byte("value")))
+	root := tree.hashSkipError()
+
+	proof, err := tree.GenerateICS23Proof([]byte("key"))
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyMembership(ics23.GravitonBlake2sSpec, root[:], proof, []byte("key"), []byte("value")))
+	calculated, err := proof.Exist.Calculate()
+	require.NoError(t, err)
+	require.Equal(t, root[:], calculated)
+
+	proof, err = tree.GenerateICS23Proof([]byte("missing"))
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyNonMembership(ics23.GravitonBlake2sSpec, root[:], proof, []byte("missing")))
+}
@@ -0,0 +1,72 @@
+package graviton
+
+import "os"
+import "fmt"
+import "io/ioutil"
+import "path/filepath"
+
+// MigrateStore upgrades a disk store to the current format version. If newdir is empty, the store is upgraded in
+// place, otherwise the upgraded store is written to newdir ( which must be empty or not exist ) and the original is
+// left untouched. All stored snapshots are rewritten in current format, version numbers and hashes do not change.
+// The store must not be open while it is being migrated. Returns the format version the store had.
+func MigrateStore(dir, newdir string) (format int, err error) {
+	dir = filepath.Clean(dir)
+	if _, err = os.Stat(filepath.Join(dir, header_file)); os.IsNotExist(err) && !has_store_data(dir) {
+		return 0, fmt.Errorf("%s does not contain a store", dir)
+	}
+	if newdir != "" {
+		if entries, err := ioutil.ReadDir(newdir); err == nil && len(entries) > 0 {
+			return 0, fmt.Errorf("migration directory %s is not empty", newdir)
+		}
+	}
+
+	if err = finish_compaction(dir); err != nil { // complete any interrupted migration
+		return 0, err
+	}
+	src := &Store{disk: new_disk_backend(dir), migrating: true, retired: true}
+	if _, err = src.init(); err != nil {
+		return src.format, err
+	}
+	format = src.format
+	if format == STORE_FORMAT_VERSION && newdir == "" {
+		src.Close()
+		return format, nil // nothing to do
+	}
+
+	// every stored version is retained, versions dropped by compaction remain dropped
+	_, highest, _, _, err := src.findhighestsnapshotinram()
+	keep := map[uint64]bool{}
+	for version := uint64(1); err == nil && version <= highest; version++ {
+		var findex, fpos uint32
+		if findex, fpos, err = src.ReadVersionData(version); err == nil && (findex != 0 || fpos != 0) {
+			keep[version] = true
+		}
+	}
+	if err != nil {
+		src.Close()
+		return format, err
+	}
+
+	if newdir != "" {
+		err = src.compact_into(filepath.Clean(newdir), keep, highest)
+		src.Close()
+		return format, err
+	}
+
+	// in place migration is a compaction which retains everything
+	tmpdir := dir + ".compact"
+	os.RemoveAll(tmpdir)
+	err = src.compact_into(tmpdir, keep, highest)
+	src.Close()
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(tmpdir, compaction_marker), nil, 0600)
+	}
+	if err == nil {
+		err = syncdir(tmpdir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return format, err
+	}
+	return format, finish_compaction(dir)
+}
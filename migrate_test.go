@@ -0,0 +1,157 @@
+package graviton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// write a store in an older format, format 0 stores have no header or a header without format version
+func setupLegacyStore(t *testing.T, dir string, format int, hash string) []map[string][HASHSIZE]byte {
+	store, err := NewDiskStore(dir, StoreOptions{Hash: hash})
+	require.NoError(t, err)
+	write_counts = false // inner nodes without leaf counts
+	hashes := setupBackupStore(t, store, 5)
+	write_counts = true
+	store.Close()
+
+	header := filepath.Join(dir, header_file)
+	switch {
+	case format == 0 && hash == HASH_BLAKE2S:
+		require.NoError(t, os.Remove(header))
+	case format == 0:
+		require.NoError(t, ioutil.WriteFile(header, []byte(fmt.Sprintf("%s\nhash %s\n", header_magic, hash)), 0600))
+	default:
+		require.NoError(t, write_header(header, storeHeader{format: format, hash: hash, max_file_size: MAX_FILE_SIZE}))
+	}
+	return hashes
+}
+
+// all versions must be present with same hashes and exact counts
+func checkMigratedStore(t *testing.T, dir string, hash string, hashes []map[string][HASHSIZE]byte) {
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	require.Equal(t, hash, store.hash.Name)
+
+	for i := range hashes {
+		gv, err := store.LoadSnapshot(uint64(i + 1))
+		require.NoError(t, err)
+		for _, treename := range []string{"tree1", "tree2"} {
+			tree, err := gv.GetTree(treename)
+			require.NoError(t, err)
+			require.Equal(t, hashes[i][treename], tree.hashSkipError())
+
+			count, err := tree.Count()
+			require.NoError(t, err)
+			require.Equal(t, uint64(countKeys(t, tree)), count)
+		}
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_migrate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	_, err = MigrateStore(filepath.Join(dir, "missing"), "")
+	require.Error(t, err)
+
+	olddir := filepath.Join(dir, "old")
+	hashes := setupLegacyStore(t, olddir, 0, HASH_BLAKE2S)
+
+	// into a new directory, original is untouched
+	newdir := filepath.Join(dir, "new")
+	format, err := MigrateStore(olddir, newdir)
+	require.NoError(t, err)
+	require.Equal(t, 0, format)
+	_, err = os.Stat(filepath.Join(olddir, header_file))
+	require.True(t, os.IsNotExist(err))
+	_, err = MigrateStore(olddir, newdir) // target must be empty
+	require.Error(t, err)
+	checkMigratedStore(t, newdir, HASH_BLAKE2S, hashes)
+
+	// in place
+	format, err = MigrateStore(olddir, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, format)
+	checkMigratedStore(t, olddir, HASH_BLAKE2S, hashes)
+	format, err = MigrateStore(olddir, "")
+	require.NoError(t, err)
+	require.Equal(t, STORE_FORMAT_VERSION, format) // nothing to do
+	_, err = os.Stat(olddir + ".compact")
+	require.True(t, os.IsNotExist(err))
+
+	// header without format version keeps its hash function
+	shadir := filepath.Join(dir, "sha0")
+	hashes = setupLegacyStore(t, shadir, 0, HASH_SHA256)
+	_, err = MigrateStore(shadir, "")
+	require.NoError(t, err)
+	checkMigratedStore(t, shadir, HASH_SHA256, hashes)
+
+	// current format without leaf counts, as written by older releases, is read as is
+	shadir = filepath.Join(dir, "sha1")
+	hashes = setupLegacyStore(t, shadir, 1, HASH_SHA256)
+	checkMigratedStore(t, shadir, HASH_SHA256, hashes)
+	format, err = MigrateStore(shadir, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, format) // nothing to do
+
+	// stores from a future release are refused
+	header := filepath.Join(shadir, header_file)
+	require.NoError(t, write_header(header, storeHeader{format: STORE_FORMAT_VERSION + 1, hash: HASH_SHA256, max_file_size: MAX_FILE_SIZE}))
+	_, err = NewDiskStore(shadir)
+	require.True(t, xerrors.Is(err, ErrUnsupportedFormat))
+	_, err = MigrateStore(shadir, "")
+	require.True(t, xerrors.Is(err, ErrUnsupportedFormat))
+
+	require.NoError(t, write_header(header, storeHeader{format: STORE_FORMAT_VERSION, hash: HASH_SHA256, max_file_size: 1024}))
+	_, err = NewDiskStore(shadir)
+	require.True(t, xerrors.Is(err, ErrUnsupportedFormat))
+}
+
+func TestLegacyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_legacy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	for _, hash := range []string{HASH_BLAKE2S, HASH_SHA256} { // without header, header without format version
+		storedir := filepath.Join(dir, hash)
+		header := filepath.Join(storedir, header_file)
+		hashes := setupLegacyStore(t, storedir, 0, hash)
+		legacyheader, _ := ioutil.ReadFile(header)
+
+		other := HASH_SHA256
+		if hash == HASH_SHA256 {
+			other = HASH_BLAKE2S
+		}
+		_, err = NewDiskStore(storedir, StoreOptions{Hash: other})
+		require.True(t, xerrors.Is(err, ErrHashMismatch))
+
+		// older stores are read as is, header is not touched till first commit
+		store, err := NewDiskStore(storedir)
+		require.NoError(t, err)
+		require.Equal(t, hash, store.hash.Name)
+		gv, err := store.LoadSnapshot(0)
+		require.NoError(t, err)
+		tree, err := gv.GetTree("tree1")
+		require.NoError(t, err)
+		require.Equal(t, hashes[len(hashes)-1]["tree1"], tree.hashSkipError())
+		current, _ := ioutil.ReadFile(header)
+		require.Equal(t, legacyheader, current)
+
+		require.NoError(t, tree.Put([]byte("newkey"), []byte("value")))
+		require.NoError(t, tree.Commit())
+		store.Close()
+
+		hdr, err := read_header(header)
+		require.NoError(t, err)
+		require.Equal(t, storeHeader{format: STORE_FORMAT_VERSION, hash: hash, max_file_size: MAX_FILE_SIZE}, hdr)
+		checkMigratedStore(t, storedir, hash, hashes)
+	}
+}
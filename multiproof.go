@@ -0,0 +1,321 @@
+package graviton
+
+import "fmt"
+import "sort"
+import "bytes"
+import "encoding/binary"
+
+import "golang.org/x/xerrors"
+
+import "github.com/deroproject/graviton/verifier"
+
+// MultiProof proves membership or non-membership of a number of keys against a single root. It carries the part of
+// the tree covering the paths of all keys, so sibling hashes shared by multiple keys are included only once.
+// Subtrees not on any path are represented by their hash.
+type MultiProof struct {
+	root *mpnode
+}
+
+// node kinds of multiproof, also used as tags while serializing
+const (
+	mp_empty    byte = iota // empty subtree
+	mp_hash                 // subtree not on any path, only its hash
+	mp_inner                // inner node on path, followed by left and right
+	mp_value                // leaf of a proven key, keyhash and value
+	mp_leaf                 // leaf at the end of a path of some other key, keyhash and value hash
+	mp_keyvalue             // leaf within a proven range, key and value
+)
+
+// node kinds allowed in each proof type
+const mp_multiproof_kinds = 1<<mp_empty | 1<<mp_hash | 1<<mp_inner | 1<<mp_value | 1<<mp_leaf
+const mp_range_kinds = 1<<mp_empty | 1<<mp_hash | 1<<mp_inner | 1<<mp_leaf | 1<<mp_keyvalue
+
+type mpnode struct {
+	kind        byte
+	hash        []byte // hash of subtree for mp_hash, value hash for mp_leaf
+	keyhash     []byte
+	key         []byte // only for mp_keyvalue
+	value       []byte
+	left, right *mpnode
+}
+
+// result of verifying a single key of a multiproof
+type KeyProof struct {
+	Proven bool // proof covers the key, otherwise nothing is known about it
+	Member bool // key exists and Value is its value
+	Value  []byte
+}
+
+// GenerateMultiProof generates a single proof for all keys, both existing and non-existing keys can be proved
+func (t *Tree) GenerateMultiProof(keys [][]byte) (*MultiProof, error) {
+	return t.generate_multiproof(keys, false)
+}
+
+func (t *Tree) generate_multiproof(keys [][]byte, witness bool) (*MultiProof, error) {
+	keyhashes := make([][HASHSIZE]byte, 0, len(keys))
+	for _, key := range keys {
+		keyhashes = append(keyhashes, t.store.hash.sum(key))
+	}
+	sort.Slice(keyhashes, func(i, j int) bool { return bytes.Compare(keyhashes[i][:], keyhashes[j][:]) < 0 })
+
+	root, err := t.multiproof(t.root, keyhashes, witness)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiProof{root: root}, nil
+}
+
+// keyhashes are all the keys which reach this node
+// in a witness, type of siblings of paths is also proved since updates may collapse them ( see transition.go )
+func (t *Tree) multiproof(n node, keyhashes [][HASHSIZE]byte, witness bool) (*mpnode, error) {
+	switch v := n.(type) {
+	case nil:
+		return &mpnode{kind: mp_empty}, nil
+
+	case *leaf:
+		if len(keyhashes) == 0 && !witness {
+			if v.loaded_partial { // hash is known from parent
+				return &mpnode{kind: mp_hash, hash: append([]byte{}, v.hash[:]...)}, nil
+			}
+			hash, err := v.Hash(t.store)
+			return &mpnode{kind: mp_hash, hash: append([]byte{}, hash...)}, err
+		}
+		if err := v.load_partial(t.store); err != nil {
+			return nil, err
+		}
+		for _, keyhash := range keyhashes {
+			if keyhash == v.keyhash {
+				return &mpnode{kind: mp_value, keyhash: append([]byte{}, v.keyhash[:]...), value: append([]byte{}, v.value...)}, nil
+			}
+		}
+		valuehash := t.store.hash.sum(v.value)
+		return &mpnode{kind: mp_leaf, keyhash: append([]byte{}, v.keyhash[:]...), hash: valuehash[:]}, nil
+
+	case *inner:
+		if len(keyhashes) == 0 && v.bit != 0 && !witness {
+			hash, err := v.Hash(t.store)
+			return &mpnode{kind: mp_hash, hash: append([]byte{}, hash...)}, err
+		}
+		if len(keyhashes) == 0 && v.bit != 0 {
+			witness = false // children hashes prove the sibling is an inner node
+		}
+		if err := v.load_partial(t.store); err != nil {
+			return nil, err
+		}
+		// keyhashes are sorted, so all keys going left come first
+		split := sort.Search(len(keyhashes), func(i int) bool { return isBitSet(keyhashes[i][:], uint(v.bit)) })
+		left, err := t.multiproof(v.left, keyhashes[:split], witness)
+		if err != nil {
+			return nil, err
+		}
+		right, err := t.multiproof(v.right, keyhashes[split:], witness)
+		if err != nil {
+			return nil, err
+		}
+		return &mpnode{kind: mp_inner, left: left, right: right}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown node type")
+	}
+}
+
+// Verify the proof against a root built with default hash function, results are in same order as keys
+func (mp *MultiProof) Verify(root [HASHSIZE]byte, keys [][]byte) ([]KeyProof, error) {
+	return mp.verify(DefaultHash, root, keys)
+}
+
+// VerifyUsing verifies the multiproof against a root of a store created with the named hash function, results
+// are in same order as keys
+func (mp *MultiProof) VerifyUsing(hashname string, root [HASHSIZE]byte, keys [][]byte) ([]KeyProof, error) {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return nil, err
+	}
+	return mp.verify(hf, root, keys)
+}
+
+func (mp *MultiProof) verify(hf *HashFunction, root [HASHSIZE]byte, keys [][]byte) ([]KeyProof, error) {
+	if mp.root == nil {
+		return nil, fmt.Errorf("empty multiproof")
+	}
+	if !bytes.Equal(mp.root.roothash(hf), root[:]) {
+		return nil, fmt.Errorf("multiproof does not match root")
+	}
+
+	results := make([]KeyProof, len(keys))
+	for i, key := range keys {
+		keyhash := hf.sum(key)
+		n := mp.root
+		for bit := uint(0); n.kind == mp_inner; bit++ {
+			if isBitSet(keyhash[:], bit) {
+				n = n.right
+			} else {
+				n = n.left
+			}
+		}
+		switch n.kind {
+		case mp_empty:
+			results[i] = KeyProof{Proven: true}
+		case mp_value, mp_leaf:
+			results[i].Proven = true
+			if bytes.Equal(n.keyhash, keyhash[:]) {
+				if n.kind != mp_value { // leaf of this key must carry the value
+					results[i].Proven = false
+					continue
+				}
+				results[i].Member = true
+				results[i].Value = append([]byte{}, n.value...)
+			}
+		}
+	}
+	return results, nil
+}
+
+// hash of the partial tree
+func (n *mpnode) roothash(hf *HashFunction) []byte {
+	switch n.kind {
+	case mp_empty:
+		return hf.zerosHash[:]
+	case mp_hash:
+		return n.hash
+	case mp_value:
+		valuehash := hf.sum(n.value)
+		return hf.leafHash(n.keyhash, valuehash[:])
+	case mp_leaf:
+		return hf.leafHash(n.keyhash, n.hash)
+	case mp_keyvalue:
+		keyhash, valuehash := hf.sum(n.key), hf.sum(n.value)
+		return hf.leafHash(keyhash[:], valuehash[:])
+	default: // mp_inner
+		var buf [2*HASHSIZE + 1]byte
+		buf[0] = innerNODE
+		copy(buf[1:], n.left.roothash(hf))
+		copy(buf[1+HASHSIZE:], n.right.roothash(hf))
+		hash := hf.sum(buf[:])
+		return hash[:]
+	}
+}
+
+// Serialize the multiproof to a byte array
+func (mp *MultiProof) Marshal() []byte {
+	var b bytes.Buffer
+	mp.MarshalTo(&b)
+	return b.Bytes()
+}
+
+// Serialize the multiproof to a bytes Buffer
+//
+//	1 byte version
+//	nodes in pre-order, each is a 1 byte kind followed by
+//		empty: nothing
+//		hash: 32 byte(HASHSIZE) hash
+//		inner: left node, right node
+//		value: 32 byte(HASHSIZE) keyhash, varint length prefixed value
+//		leaf: 32 byte(HASHSIZE) keyhash, 32 byte(HASHSIZE) value hash
+//		keyvalue: varint length prefixed key, varint length prefixed value ( only in range proofs )
+func (mp *MultiProof) MarshalTo(b *bytes.Buffer) {
+	b.WriteByte(1) // write version
+	if mp.root != nil {
+		mp.root.marshal(b)
+	}
+}
+
+func (n *mpnode) marshal(b *bytes.Buffer) {
+	b.WriteByte(n.kind)
+	switch n.kind {
+	case mp_hash:
+		b.Write(n.hash)
+	case mp_inner:
+		n.left.marshal(b)
+		n.right.marshal(b)
+	case mp_value:
+		var buf [binary.MaxVarintLen64]byte
+		b.Write(n.keyhash)
+		b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(n.value)))])
+		b.Write(n.value)
+	case mp_leaf:
+		b.Write(n.keyhash)
+		b.Write(n.hash)
+	case mp_keyvalue:
+		var buf [binary.MaxVarintLen64]byte
+		b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(n.key)))])
+		b.Write(n.key)
+		b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(n.value)))])
+		b.Write(n.value)
+	}
+}
+
+// Unmarshal follows reverse of marshal to deserialize the array of bytes to multiproof for verification
+// errors wrap ErrProofVersion, ErrTruncatedProof or ErrMalformedProof
+func (mp *MultiProof) Unmarshal(buf []byte) error {
+	root, err := unmarshal_mproot(buf, mp_multiproof_kinds)
+	mp.root = root
+	return err
+}
+
+func unmarshal_mproot(buf []byte, kinds uint) (*mpnode, error) {
+	if len(buf) < 1 {
+		return nil, xerrors.Errorf("%w: %d bytes", ErrTruncatedProof, len(buf))
+	}
+	if buf[0] != 1 {
+		return nil, xerrors.Errorf("%w: %d", ErrProofVersion, buf[0])
+	}
+	root, done, err := unmarshal_mpnode(buf, 1, 0, kinds)
+	if err != nil {
+		return nil, err
+	}
+	if done != len(buf) {
+		return nil, xerrors.Errorf("%w: %d extra bytes", ErrMalformedProof, len(buf)-done)
+	}
+	return root, nil
+}
+
+// node at pos, kinds is a bit mask of allowed node kinds, returns position after the node
+func unmarshal_mpnode(buf []byte, pos int, bit int, kinds uint) (n *mpnode, done int, err error) {
+	if len(buf) <= pos {
+		return nil, pos, xerrors.Errorf("%w: node", ErrTruncatedProof)
+	}
+	n = &mpnode{kind: buf[pos]}
+	done = pos + 1
+	if n.kind >= 8 || kinds&(1<<n.kind) == 0 {
+		return nil, pos, xerrors.Errorf("%w: node kind %d", ErrMalformedProof, n.kind)
+	}
+
+	read_hash := func() (hash []byte, err error) {
+		if len(buf) < done+HASHSIZE {
+			return nil, xerrors.Errorf("%w: hash", ErrTruncatedProof)
+		}
+		hash = append([]byte{}, buf[done:done+HASHSIZE]...)
+		done += HASHSIZE
+		return
+	}
+
+	switch n.kind {
+	case mp_empty:
+	case mp_hash:
+		n.hash, err = read_hash()
+	case mp_inner:
+		if bit > lastBit {
+			return nil, pos, xerrors.Errorf("%w: too deep", ErrMalformedProof)
+		}
+		if n.left, done, err = unmarshal_mpnode(buf, done, bit+1, kinds); err == nil {
+			n.right, done, err = unmarshal_mpnode(buf, done, bit+1, kinds)
+		}
+	case mp_value:
+		if n.keyhash, err = read_hash(); err == nil {
+			n.value, done, err = verifier.ReadBytes(buf, done, MAX_VALUE_SIZE)
+		}
+	case mp_keyvalue:
+		if n.key, done, err = verifier.ReadBytes(buf, done, MAX_KEYSIZE); err == nil {
+			n.value, done, err = verifier.ReadBytes(buf, done, MAX_VALUE_SIZE)
+		}
+	case mp_leaf:
+		if n.keyhash, err = read_hash(); err == nil {
+			n.hash, err = read_hash()
+		}
+	}
+	if err != nil {
+		return nil, pos, err
+	}
+	return
+}
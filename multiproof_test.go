@@ -0,0 +1,147 @@
+package graviton
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestMultiProof(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, tree.Commit())
+	root := tree.hashSkipError()
+
+	// reload so as proof is generated from partially loaded nodes
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+
+	var keys [][]byte
+	for i := 0; i < 1000; i += 50 { // half of the keys do not exist
+		keys = append(keys, []byte(fmt.Sprintf("key%d", i)))
+	}
+
+	mp, err := tree.GenerateMultiProof(keys)
+	require.NoError(t, err)
+
+	var decoded MultiProof
+	buf := mp.Marshal()
+	require.NoError(t, decoded.Unmarshal(buf))
+	require.Equal(t, buf, decoded.Marshal())
+
+	results, err := decoded.Verify(root, keys)
+	require.NoError(t, err)
+	require.Len(t, results, len(keys))
+
+	individual := 0
+	for i, key := range keys {
+		proof, err := tree.GenerateProof(key)
+		require.NoError(t, err)
+		individual += len(proof.Marshal())
+
+		require.True(t, results[i].Proven)
+		require.Equal(t, proof.VerifyMembership(root, key), results[i].Member, "key %s", key)
+		require.Equal(t, proof.VerifyNonMembership(root, key), !results[i].Member, "key %s", key)
+		if results[i].Member {
+			require.Equal(t, proof.Value(), results[i].Value)
+		}
+	}
+	require.Less(t, len(buf), individual) // shared siblings are included once
+
+	// keys not covered by the proof are not proven
+	results, err = decoded.Verify(root, [][]byte{[]byte("key1"), []byte("key50")})
+	require.NoError(t, err)
+	require.False(t, results[0].Proven)
+	require.True(t, results[1].Proven)
+	require.Equal(t, []byte("value50"), results[1].Value)
+
+	// wrong root
+	root[0] ^= 1
+	_, err = decoded.Verify(root, keys)
+	require.Error(t, err)
+	root[0] ^= 1
+
+	// tampered value changes root
+	tampered := append([]byte{}, buf...)
+	tampered[len(tampered)-1] ^= 1
+	if decoded.Unmarshal(tampered) == nil {
+		_, err = decoded.Verify(root, keys)
+		require.Error(t, err)
+	}
+
+	// truncated and extended proofs
+	for i := 0; i < len(buf); i++ {
+		require.True(t, xerrors.Is(decoded.Unmarshal(buf[:i]), ErrTruncatedProof))
+	}
+	require.True(t, xerrors.Is(decoded.Unmarshal(append(append([]byte{}, buf...), 0)), ErrMalformedProof))
+	require.True(t, xerrors.Is(decoded.Unmarshal([]byte{2}), ErrProofVersion))
+
+	// invalid node kind
+	require.Error(t, decoded.Unmarshal([]byte{1, 9}))
+
+	// proof which is too deep
+	deep := []byte{1}
+	for i := 0; i <= HASHSIZE*8; i++ {
+		deep = append(deep, mp_inner)
+	}
+	require.Error(t, decoded.Unmarshal(deep))
+}
+
+func TestMultiProofEmpty(t *testing.T) {
+	_, tree := setupDeterministicTree(t, 0)
+	keys := [][]byte{[]byte("a"), []byte("b")}
+
+	mp, err := tree.GenerateMultiProof(keys)
+	require.NoError(t, err)
+	results, err := mp.Verify(tree.hashSkipError(), keys)
+	require.NoError(t, err)
+	for _, r := range results {
+		require.True(t, r.Proven)
+		require.False(t, r.Member)
+	}
+
+	// proof without keys still proves the root
+	_, tree = setupDeterministicTree(t, 10)
+	mp, err = tree.GenerateMultiProof(nil)
+	require.NoError(t, err)
+	_, err = mp.Verify(tree.hashSkipError(), nil)
+	require.NoError(t, err)
+}
+
+func TestMultiProofHash(t *testing.T) {
+	store, err := NewMemStore(StoreOptions{Hash: HASH_SHA256})
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	keys := [][]byte{[]byte("key7"), []byte("key77"), []byte("missing")}
+	mp, err := tree.GenerateMultiProof(keys)
+	require.NoError(t, err)
+
+	results, err := mp.VerifyUsing(HASH_SHA256, tree.hashSkipError(), keys)
+	require.NoError(t, err)
+	require.True(t, results[0].Member)
+	require.True(t, results[1].Member)
+	require.False(t, results[2].Member)
+	require.True(t, results[2].Proven)
+
+	_, err = mp.Verify(tree.hashSkipError(), keys)
+	require.Error(t, err)
+}
@@ -23,6 +23,9 @@ type inner struct {
 	version_previous uint64 // previous version
 	version_current  uint64 // currentversion
 
+	count   uint64 // number of leaves beneath this node, only valid if counted
+	counted bool
+
 	dirty, loaded_partial bool
 	bit                   uint8
 }
@@ -49,14 +52,14 @@ func (in *inner) lhash(store *Store) ([]byte, error) {
 	if in.left != nil {
 		return in.left.Hash(store)
 	}
-	return zerosHash[:], nil
+	return store.hash.zerosHash[:], nil
 }
 
 func (in *inner) rhash(store *Store) ([]byte, error) {
 	if in.right != nil {
 		return in.right.Hash(store)
 	}
-	return zerosHash[:], nil
+	return store.hash.zerosHash[:], nil
 }
 
 func (in *inner) load_partial(store *Store) error {
@@ -87,7 +90,7 @@ func (in *inner) Hash(store *Store) ([]byte, error) {
 		if rhash, err = in.rhash(store); err == nil {
 			copy(buf[1+HASHSIZE_BYTES:], rhash)
 
-			hash := sum(buf[:])
+			hash := store.hash.sum(buf[:])
 			in.hash = append(in.hash[:0], hash[:]...)
 
 			return in.hash, nil
@@ -101,6 +104,52 @@ func (in *inner) Position() (uint32, uint32) {
 	return in.findex, in.fpos
 }
 
+// number of leaves beneath this node, counts of stored nodes are recorded in their parents
+func (in *inner) Count(store *Store) (uint64, error) {
+	if in.counted {
+		return in.count, nil
+	}
+	if err := in.load_partial(store); err != nil {
+		return 0, err
+	}
+	lcount, err := node_count(store, in.left)
+	if err != nil {
+		return 0, err
+	}
+	rcount, err := node_count(store, in.right)
+	if err != nil {
+		return 0, err
+	}
+	in.count, in.counted = lcount+rcount, true
+	return in.count, nil
+}
+
+func node_count(store *Store, n node) (uint64, error) {
+	switch v := n.(type) {
+	case nil:
+		return 0, nil
+	case *leaf:
+		return 1, nil
+	case *inner:
+		return v.Count(store)
+	default:
+		return 0, xerrors.Errorf("unknown node type")
+	}
+}
+
+// leaf count of a node if it is known without loading anything, nodes written by older releases carry no counts
+func known_count(n node) (uint64, bool) {
+	switch v := n.(type) {
+	case nil:
+		return 0, true
+	case *leaf:
+		return 1, true
+	case *inner:
+		return v.count, v.counted
+	}
+	return 0, false
+}
+
 // all puts must be checked with deduplication and skipped if duplicate
 func (in *inner) Insert(store *Store, nodes ...*leaf) error {
 	if err := in.load_partial(store); err != nil { // if inner node is loaded partially, load it fully now
@@ -108,6 +157,7 @@ func (in *inner) Insert(store *Store, nodes ...*leaf) error {
 	}
 	in.dirty = true       // mark node as dirty
 	in.hash = in.hash[:0] // cleanup old hash
+	in.counted = false
 	for _, n := range nodes {
 		if err := in.insert(store, n); err != nil {
 			return err
@@ -232,6 +282,7 @@ func (in *inner) Delete(store *Store, keyhash [HASHSIZE]byte) (bool, bool, error
 		if changed {
 			in.dirty = true
 			in.hash = in.hash[:0]
+			in.counted = false
 		}
 		if empty {
 			in.right = nil
@@ -254,6 +305,7 @@ func (in *inner) Delete(store *Store, keyhash [HASHSIZE]byte) (bool, bool, error
 	if changed {
 		in.dirty = true
 		in.hash = in.hash[:0]
+		in.counted = false
 	}
 	if empty {
 		in.left = nil
@@ -287,11 +339,12 @@ func (in *inner) Prove(store *Store, keyhash [HASHSIZE]byte, proof *Proof) error
 	var err error
 	if err = in.load_partial(store); err == nil { // if inner node is loaded partially, load it fully now
 
-		proof.version = 1
-
 		if isBitSet(keyhash[:], uint(in.bit)) {
 			var lhash []byte
 			if lhash, err = in.lhash(store); err == nil {
+				if in.left == nil { // hash of empty sibling depends on hash function, so it is not part of proof
+					lhash = nil
+				}
 				proof.addTrace(lhash)
 				if in.right != nil {
 					return in.right.Prove(store, keyhash, proof)
@@ -306,6 +359,9 @@ func (in *inner) Prove(store *Store, keyhash [HASHSIZE]byte, proof *Proof) error
 
 	var rhash []byte
 	if rhash, err = in.rhash(store); err == nil {
+		if in.right == nil {
+			rhash = nil
+		}
 		proof.addTrace(rhash)
 		if in.left != nil {
 			return in.left.Prove(store, keyhash, proof)
@@ -316,7 +372,10 @@ func (in *inner) Prove(store *Store, keyhash [HASHSIZE]byte, proof *Proof) error
 
 }
 
-// minimum size is 3 bytes
+var write_counts = true // tests disable it to write nodes as older releases did
+
+// minimum size is 3 bytes, leaf counts of inner children follow the children if they are known without loading
+// them. readers which do not know about counts ignore them, since they stop after the children
 func (in *inner) MarshalTo(store *Store, buf []byte, bucket string) (int, error) {
 	buf[1] = getNodeType(in.left)  // 1
 	buf[2] = getNodeType(in.right) // 1 + 1
@@ -360,6 +419,24 @@ func (in *inner) MarshalTo(store *Store, buf []byte, bucket string) (int, error)
 		done += copy(buf[done:done+32], rhash) // insert right hash
 	}
 
+	lcount, lok := known_count(in.left)
+	rcount, rok := known_count(in.right)
+	if lok && rok && write_counts { // cache own count, children may be released after marshalling
+		in.count, in.counted = lcount+rcount, true
+
+		var tbuf [2 * binary.MaxVarintLen64]byte
+		tsize := 0
+		if getNodeType(in.left) == innerNODE {
+			tsize += binary.PutUvarint(tbuf[tsize:], lcount)
+		}
+		if getNodeType(in.right) == innerNODE {
+			tsize += binary.PutUvarint(tbuf[tsize:], rcount)
+		}
+		if done+tsize <= 255 { // length is a single byte
+			done += copy(buf[done:], tbuf[:tsize])
+		}
+	}
+
 	buf[0] = byte(done) // prepend with length
 
 	for i := range errors {
@@ -445,9 +522,8 @@ func parse_node(level byte, nodetype byte, buf []byte) (node, int, error) {
 	}
 }
 
-// first byte is skipped and processed elsewhere
+// first byte is length of node, buffer may extend beyond it
 func (in *inner) Unmarshal(buf []byte) (err error) {
-
 	/*length, length_bytes := binary.Varint(buf)
 	if length_bytes <0 || length <= 0 ||  len(buf) < (int(length) + length_bytes)  {
 		panic("inner node length cannot be zero")
@@ -460,7 +536,6 @@ func (in *inner) Unmarshal(buf []byte) (err error) {
 
 	length_bytes := 1
 	length := int(uint(buf[0]))
-	_ = length
 
 	buf = buf[length_bytes:]
 
@@ -468,10 +543,19 @@ func (in *inner) Unmarshal(buf []byte) (err error) {
 	var tsize int
 	if in.bit == 0 { // it's a root node so write current and previous version number also
 		in.version_current, tsize = binary.Uvarint(buf[done:]) // current version
+		if tsize <= 0 {
+			return xerrors.Errorf("invalid root version")
+		}
 		done += tsize
 		in.version_previous, tsize = binary.Uvarint(buf[done:]) // previous version
+		if tsize <= 0 {
+			return xerrors.Errorf("invalid root previous version")
+		}
 		done += tsize
 		blen, tsize := binary.Uvarint(buf[done:])
+		if tsize <= 0 || blen > uint64(len(buf)-done-tsize) {
+			return xerrors.Errorf("invalid bucket name length")
+		}
 		done += tsize
 
 		//var lbuf[BUCKET_NAME_LIMIT]byte
@@ -492,6 +576,27 @@ func (in *inner) Unmarshal(buf []byte) (err error) {
 	if err != nil {
 		return
 	}
+	done += tsize
 
+	if end := length - length_bytes; done < end && end <= len(buf) { // leaf counts of inner children
+		for _, child := range []node{in.left, in.right} {
+			if v, ok := child.(*inner); ok {
+				if v.count, tsize = binary.Uvarint(buf[done:end]); tsize <= 0 {
+					return xerrors.Errorf("Probably data corruption, invalid leaf count")
+				}
+				v.counted = true
+				done += tsize
+			}
+		}
+		if done != end {
+			return xerrors.Errorf("Probably data corruption, invalid leaf counts")
+		}
+	}
+
+	lcount, lok := known_count(in.left)
+	rcount, rok := known_count(in.right)
+	if lok && rok { // children counts are known without loading them
+		in.count, in.counted = lcount+rcount, true
+	}
 	return
 }
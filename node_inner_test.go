@@ -62,7 +62,7 @@ func TestLoadinnerfromstore(t *testing.T) {
 		t.Fatalf("Hash inner node loading failed")
 	}
 
-	_, err = in.Get(store, sum([]byte("dummykey"))) // trigger load error for Get
+	_, err = in.Get(store, DefaultHash.sum([]byte("dummykey"))) // trigger load error for Get
 	require.Error(t, err)
 
 	var l leaf
@@ -70,10 +70,10 @@ func TestLoadinnerfromstore(t *testing.T) {
 	require.Error(t, err)
 
 	//var p Proof
-	//err = in.Prove(store, sum([]byte("dummykey")), &p) // trigger load error for Prove
+	//err = in.Prove(store, DefaultHash.sum([]byte("dummykey")), &p) // trigger load error for Prove
 	//require.Error(t, err)
 
-	_, _, err = in.Delete(store, sum([]byte("dummykey"))) // trigger load error for Delete
+	_, _, err = in.Delete(store, DefaultHash.sum([]byte("dummykey"))) // trigger load error for Delete
 	require.Error(t, err)
 
 	{ // n level deep Delete errors are simulated here
@@ -27,7 +27,7 @@ type leaf struct {
 	loaded_partial bool
 }
 
-func newLeaf(keyhash [HASHSIZE]byte, key, value []byte) *leaf {
+func newLeaf(store *Store, keyhash [HASHSIZE]byte, key, value []byte) *leaf {
 	key_copy := make([]byte, len(key))
 	copy(key_copy, key[:])
 	value_copy := make([]byte, len(value))
@@ -40,8 +40,8 @@ func newLeaf(keyhash [HASHSIZE]byte, key, value []byte) *leaf {
 	}
 	l.key = append(l.keybuf[:0], key...)
 
-	rst := sum(l.value)
-	copy(l.hash_check[:], leafHash(l.keyhash[:], rst[:]))
+	rst := store.hash.sum(l.value)
+	copy(l.hash_check[:], store.hash.leafHash(l.keyhash[:], rst[:]))
 	copy(l.hash[:], l.hash_check[:])
 
 	l.leaf_init = true
@@ -50,16 +50,6 @@ func newLeaf(keyhash [HASHSIZE]byte, key, value []byte) *leaf {
 	return l
 }
 
-func leafHash(hkey, hvalue []byte) []byte {
-	rst := make([]byte, 0, HASHSIZE)
-	h := hasher()
-	h.Write([]byte{leafNODE})
-	h.Write(hkey)
-	h.Write(hvalue)
-	rst = h.Sum(rst)
-	return rst
-}
-
 func (l *leaf) Hash(store *Store) ([]byte, error) {
 	if l.loaded_partial { // if leaf is loaded partially, load it fully now
 		if err := l.loadfullleaffromstore(store); err != nil {
@@ -86,8 +76,8 @@ func (l *leaf) Put(store *Store, keyhash [HASHSIZE]byte, value []byte) error {
 	}
 	// overwrite created new branch. Old versions are all accessible using previous root
 	l.value = value
-	rst := sum(l.value)
-	copy(l.hash[:], leafHash(l.keyhash[:], rst[:])) // use hash of key and hash of value
+	rst := store.hash.sum(l.value)
+	copy(l.hash[:], store.hash.leafHash(l.keyhash[:], rst[:])) // use hash of key and hash of value
 	copy(l.hash_check[:], l.hash[:])
 	l.dirty = true
 	l.findex, l.fpos = 0, 0
@@ -118,6 +108,43 @@ func (l *leaf) Delete(store *Store, keyhash [HASHSIZE]byte) (bool, bool, error)
 	return match, match, nil
 }
 
+// serialize key and value, this is the on disk format of leaf
+func (l *leaf) MarshalTo(b *bytes.Buffer) {
+	var tbuf [10]byte
+
+	size := binary.PutUvarint(tbuf[:], uint64(len(l.key)))
+	b.Write(tbuf[:size])
+	if len(l.key) > 0 {
+		b.Write(l.key[:])
+	}
+	size = binary.PutUvarint(tbuf[:], uint64(len(l.value)))
+	b.Write(tbuf[:size])
+	if len(l.value) > 0 {
+		b.Write(l.value[:])
+	}
+}
+
+// parse a complete serialized leaf and setup key, value, keyhash and hash
+func (l *leaf) Unmarshal(store *Store, buf []byte) error {
+	keylen, keysize := binary.Uvarint(buf)
+	if keysize <= 0 || uint64(len(buf)-keysize) < keylen {
+		return xerrors.Errorf("invalid key size")
+	}
+	done := keysize + int(keylen)
+	valuelen, valuesize := binary.Uvarint(buf[done:])
+	if valuesize <= 0 || uint64(len(buf)-done-valuesize) != valuelen {
+		return xerrors.Errorf("invalid value size")
+	}
+
+	l.key = append(l.keybuf[:0], buf[keysize:done]...)
+	l.value = append(l.value[:0], buf[done+valuesize:]...)
+	l.keyhash = store.hash.sum(l.key)
+	rst := store.hash.sum(l.value)
+	copy(l.hash[:], store.hash.leafHash(l.keyhash[:], rst[:]))
+	l.leaf_init = true
+	return nil
+}
+
 func (l *leaf) load_partial(store *Store) error {
 	if l.loaded_partial { // if leaf is loaded partially, load it fully now
 		return l.loadfullleaffromstore(store)
@@ -167,12 +194,12 @@ read_again:
 
 	// time for data integrity
 
-	l.keyhash = sum(l.key)
+	l.keyhash = store.hash.sum(l.key)
 
 	// we also need to calculate hash, see whether it matches with what is stored
 
-	rst := sum(l.value)
-	copy(l.hash[:], leafHash(l.keyhash[:], rst[:])) // use hash of key and hash of value
+	rst := store.hash.sum(l.value)
+	copy(l.hash[:], store.hash.leafHash(l.keyhash[:], rst[:])) // use hash of key and hash of value
 
 	if gET_CHECKED {
 		if bytes.Compare(l.hash_check[:], l.hash[:]) != 0 {
@@ -199,7 +226,7 @@ func (l *leaf) Prove(store *Store, keyhash [HASHSIZE]byte, proof *Proof) error {
 		proof.addValue(l.value)
 		return nil
 	}
-	rst := sum(l.value)
+	rst := store.hash.sum(l.value)
 	proof.addCollision(l.keyhash[:], rst[:])
 	return nil
 }
@@ -25,17 +25,17 @@ func TestLoadfullleaffromstore(t *testing.T) {
 	var l leaf
 	l.loaded_partial = true
 
-	_, err = l.Get(store, sum([]byte("dummykey"))) // trigger load error for Get
+	_, err = l.Get(store, DefaultHash.sum([]byte("dummykey"))) // trigger load error for Get
 	require.Error(t, err)
 
-	_, _, err = l.Delete(store, sum([]byte("dummykey"))) // trigger load error for Delete
+	_, _, err = l.Delete(store, DefaultHash.sum([]byte("dummykey"))) // trigger load error for Delete
 	require.Error(t, err)
 
 	var p Proof
-	err = l.Prove(store, sum([]byte("dummykey")), &p) // trigger load error for Prove
+	err = l.Prove(store, DefaultHash.sum([]byte("dummykey")), &p) // trigger load error for Prove
 	require.Error(t, err)
 
-	err = l.Put(store, sum([]byte("dummykey")), []byte("dummyvalue")) // trigger load error for Get
+	err = l.Put(store, DefaultHash.sum([]byte("dummykey")), []byte("dummyvalue")) // trigger load error for Get
 	require.Error(t, err)
 
 	l.findex = 100000
@@ -1,217 +1,155 @@
 package graviton
 
-import "fmt"
 import "bytes"
-import "encoding/binary"
 
+import "github.com/deroproject/graviton/verifier"
+
+// proof types, encoding and verification of proofs live in the verifier package, so as light clients share the code
 const (
-	member byte = iota + 1
-	collision
-	deadend
+	member     = verifier.Member
+	collision  = verifier.Collision
+	deadend    = verifier.Deadend
+	memberhash = verifier.MemberHash // member, but only hash of value is carried
 )
 
 func NewProof() *Proof {
-	return &Proof{
-		trace: make([][]byte, 0, HASHSIZE*32),
-	}
+	return &Proof{}
 }
 
 //This structure is used to prove existence/non-existence of a key
 type Proof struct {
-	version int64
-	ptype   byte
-	trace   [][]byte
-	value   []byte // can this be a problem, maybe we should decouple value and replace it with hash of value,
-
-	ckey, cval []byte // collision key and value
+	p verifier.Proof
 }
 
 // prepare the structure for reuse
 func (p *Proof) Reset() {
-	p.version = 0
-	for i := range p.trace {
-		p.trace[i] = nil
-	}
-	p.value = nil
-	p.ckey = nil
-	p.cval = nil
-	p.ptype = 0
-	p.trace = p.trace[:0]
+	p.p.Reset()
 }
 
 // add paths
 func (p *Proof) addTrace(hash []byte) {
-	p.trace = append(p.trace, hash)
+	p.p.AddTrace(hash)
 }
 
 func (p *Proof) addDeadend() {
-	p.ptype = deadend
+	p.p.SetDeadend()
 }
 
 func (p *Proof) addValue(value []byte) {
-	p.ptype = member
-	p.value = value
+	p.p.SetMember(value)
 }
 
-func (p *Proof) addCollision(key, val []byte) {
-	p.ptype = collision
-	p.ckey = key
-	p.cval = val
-}
-
-func (p *Proof) rootForLeaf(keyhash [HASHSIZE]byte, leaf []byte) []byte {
-	h := hasher()
-	rst := make([]byte, HASHSIZE)
-	copy(rst, leaf)
-	last := len(p.trace) - 1
-	for i := uint(last); ; i-- {
-		sibling := p.trace[i]
-		h.Write([]byte{innerNODE})
-		if isBitSet(keyhash[:], i) {
-			h.Write(sibling)
-			h.Write(rst)
-		} else {
-			h.Write(rst)
-			h.Write(sibling)
-		}
-		rst = h.Sum(rst[:0])
-		h.Reset()
-		if i == 0 {
-			break
-		}
-	}
-	return rst
+// replace value with its hash, so as large values can be fetched separately and checked using VerifyValue
+func (p *Proof) hashValue(hf *HashFunction) {
+	p.p.HashValue(hf.new)
 }
 
-func (p *Proof) VerifyMembership(root [HASHSIZE]byte, key []byte) bool {
-	return p.verifyMembershipRaw(root, sum(key))
+func (p *Proof) addCollision(key, val []byte) {
+	p.p.SetCollision(key, val)
 }
 
-func (p *Proof) verifyMembershipRaw(root [HASHSIZE]byte, key [HASHSIZE]byte) bool {
-	rst := sum(p.value)
-	return bytes.Compare(root[:], p.rootForLeaf(key, leafHash(key[:], rst[:]))) == 0
+func (p *Proof) ptype() byte {
+	return p.p.Type()
 }
 
-func (p *Proof) VerifyNonMembership(root [HASHSIZE]byte, key []byte) bool {
-	return p.verifyNonMembershipRaw(root, sum(key))
+// verify membership of a key in a tree built with default hash function
+func (p *Proof) VerifyMembership(root [HASHSIZE]byte, key []byte) bool {
+	return p.verifyMembershipRaw(DefaultHash, root, DefaultHash.sum(key))
 }
 
-func (p *Proof) verifyNonMembershipRaw(root [HASHSIZE]byte, key [HASHSIZE]byte) bool {
-	if p.ptype == collision {
-		return bytes.Compare(root[:], p.rootForLeaf(key, leafHash(p.ckey, p.cval))) == 0
-	}
-	if p.ptype == deadend {
-		return bytes.Compare(root[:], p.rootForLeaf(key, zerosHash[:])) == 0
+// VerifyMembershipUsing is VerifyMembership for stores created with another hash function, hashname is the
+// StoreOptions.Hash of the store. Proofs carrying only the value hash are rejected, unknown hash names fail
+func (p *Proof) VerifyMembershipUsing(hashname string, root [HASHSIZE]byte, key []byte) bool {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return false
 	}
-	return false
+	return p.verifyMembershipRaw(hf, root, hf.sum(key))
 }
 
-// if the proof is for existence for a key, it's associated value can be read here
-func (p *Proof) Value() []byte {
-	if p.value == nil {
-		return []byte{}
+func (p *Proof) verifyMembershipRaw(hf *HashFunction, root [HASHSIZE]byte, key [HASHSIZE]byte) bool {
+	if p.ptype() != member { // proof must carry the value
+		return false
 	}
-	rst := make([]byte, len(p.value))
-	copy(rst, p.value)
-	return rst
+	return p.verifyValueHashRaw(hf, root, key)
 }
 
-// Serialize the proof to a byte array
-func (p *Proof) Marshal() []byte {
-	var b bytes.Buffer
-	p.MarshalTo(&b)
-	return b.Bytes()
-}
-
-// Serialize the proof to a bytes Buffer
-// 		the following are the size requirements for proof
-// 		1 byte for version
-// 		1 byte for type
-// 		varint trace length
-// 		32 byte(HASHSIZE) tracebits, bit 1 is set  if hash is not zerohash
-// 		32 byte(HASHSIZE) * number of trace bits set
-// 		if collision is there 32 byte(HASHSIZE) key , 32 byte(HASHSIZE) value
-// 		if member, varint lenth var int length prefixed value
-// 		dead end = 0
-func (p *Proof) MarshalTo(b *bytes.Buffer) {
-
-	var buf [10]byte
-	var emptyhash [HASHSIZE]byte // used for proof bits
-	b.WriteByte(1)               // write version
-	b.WriteByte(p.ptype)         // write proof type
+// verify membership of a key in a tree built with default hash function, proof may carry only the hash of value
+// the value itself is not authenticated till it is checked using VerifyValue
+func (p *Proof) VerifyMembershipWithValueHash(root [HASHSIZE]byte, key []byte) bool {
+	return p.verifyValueHashRaw(DefaultHash, root, DefaultHash.sum(key))
+}
 
-	done := binary.PutUvarint(buf[:], uint64(len(p.trace)))
-	b.Write(buf[:done])
+// VerifyMembershipWithValueHashUsing accepts proofs carrying either the value or only its hash, for trees of the
+// named hash function. A value fetched separately must then be checked using VerifyValueUsing with same name
+func (p *Proof) VerifyMembershipWithValueHashUsing(hashname string, root [HASHSIZE]byte, key []byte) bool {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return false
+	}
+	return p.verifyValueHashRaw(hf, root, hf.sum(key))
+}
 
-	tracebits_pos := 2 + done
-	b.Write(emptyhash[:])
+func (p *Proof) verifyValueHashRaw(hf *HashFunction, root [HASHSIZE]byte, key [HASHSIZE]byte) bool {
+	valid, member := p.p.CheckKeyHash(hf.new, hf.zerosHash[:], root, key[:])
+	return valid && member
+}
 
-	tracebits := b.Bytes()
-	tracebits = tracebits[tracebits_pos:] // can buffer relocation cause any issues
+// VerifyValue checks a value fetched separately against a membership proof of a tree built with default hash
+// function, the proof itself must be verified using VerifyMembershipWithValueHash
+func (p *Proof) VerifyValue(value []byte) bool {
+	return p.p.CheckValue(DefaultHash.new, value)
+}
 
-	for i := range p.trace {
-		if bytes.Compare(p.trace[i], zerosHash[:]) != 0 {
-			setBit(tracebits, uint(i))
-			b.Write(p.trace[i])
-		}
+// VerifyValueUsing hashes value with the named hash function and compares it with the value hash carried by a
+// membership proof, it is the second step after VerifyMembershipWithValueHashUsing
+func (p *Proof) VerifyValueUsing(hashname string, value []byte) bool {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return false
 	}
-	switch p.ptype {
-	case collision:
-		b.Write(p.ckey) // HASHSIZE len
-		b.Write(p.cval) // HASHSIZE len
-	case member:
-		done := binary.PutUvarint(buf[:], uint64(len(p.value)))
-		b.Write(buf[:done])
-		b.Write(p.value[:]) // why don't we write value hash and dispatch value separately,
-	case deadend:
+	return p.p.CheckValue(hf.new, value)
+}
+
+// verify non membership of a key in a tree built with default hash function
+func (p *Proof) VerifyNonMembership(root [HASHSIZE]byte, key []byte) bool {
+	return p.verifyNonMembershipRaw(DefaultHash, root, DefaultHash.sum(key))
+}
+
+// VerifyNonMembershipUsing proves that key is absent from a tree of the named hash function, using a collision or
+// deadend proof. Membership proofs and unknown hash names fail
+func (p *Proof) VerifyNonMembershipUsing(hashname string, root [HASHSIZE]byte, key []byte) bool {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return false
 	}
+	return p.verifyNonMembershipRaw(hf, root, hf.sum(key))
+}
 
-	bb := b.Bytes()
-	copy(bb[tracebits_pos:], tracebits)
+func (p *Proof) verifyNonMembershipRaw(hf *HashFunction, root [HASHSIZE]byte, key [HASHSIZE]byte) bool {
+	valid, member := p.p.CheckKeyHash(hf.new, hf.zerosHash[:], root, key[:])
+	return valid && !member
 }
 
-// Unmarshal follows reverse of marshal to deserialize the array of bytes to proof for verification.
-func (p *Proof) Unmarshal(buf []byte) error {
+// if the proof is for existence for a key, it's associated value can be read here, proofs carrying only
+// the hash of value return empty value
+func (p *Proof) Value() []byte {
+	return p.p.Value()
+}
 
-	p.Reset() // reset complete proof
+// Serialize the proof to a byte array
+func (p *Proof) Marshal() []byte {
+	return p.p.Marshal()
+}
 
-	p.version = int64(buf[0]) // note we are currently not checking versio
-	p.ptype = buf[1]
-	tracelength, tracelengthsize := binary.Uvarint(buf[2:])
-	if tracelengthsize <= 0 || tracelength < 1 {
-		return fmt.Errorf("invalid proof tracelength")
-	}
-	p.trace = make([][]byte, tracelength)
-	done := 1 + 1 + int(tracelengthsize)
-	tracebits := buf[done : done+HASHSIZE]
-
-	done += HASHSIZE
-	for i := range p.trace {
-		if isBitSet(tracebits, uint(i)) {
-			p.trace[i] = make([]byte, HASHSIZE)
-			copy(p.trace[i], buf[done:]) // copy only HASHSIZE
-			done += HASHSIZE
-		} else {
-			p.trace[i] = zerosHash[:] // if any bit is not set, use zerohash
-		}
-	}
-	switch p.ptype {
-	case collision:
-		p.ckey = make([]byte, HASHSIZE)
-		copy(p.ckey, buf[done:])
-		done += HASHSIZE
-		p.cval = make([]byte, HASHSIZE)
-		copy(p.cval, buf[done:])
-	case member:
-		valuelength, valuelengthsize := binary.Uvarint(buf[done:])
-
-		if valuelengthsize <= 0 {
-			return fmt.Errorf("invalid proof")
-		}
-		done += int(valuelengthsize)
-		p.value = make([]byte, valuelength)
-		copy(p.value, buf[done:])
+// Serialize the proof to a bytes Buffer, see verifier.Proof.Unmarshal for the format
+func (p *Proof) MarshalTo(b *bytes.Buffer) {
+	p.p.MarshalTo(b)
+}
 
-	}
-	return nil
+// Unmarshal follows reverse of marshal to deserialize the array of bytes to proof for verification.
+// proofs from untrusted peers are safe to decode, every length is checked and only the canonical encoding of a proof
+// is accepted, errors wrap ErrProofVersion, ErrTruncatedProof or ErrMalformedProof
+func (p *Proof) Unmarshal(buf []byte) error {
+	return p.p.Unmarshal(buf)
 }
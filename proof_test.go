@@ -1,11 +1,14 @@
 package graviton
 
 import (
+	"bytes"
+	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 )
 
 func TestTreeProve(t *testing.T) {
@@ -88,9 +91,9 @@ func TestProveCollision(t *testing.T) {
 	proof := NewProof()
 	require.NoError(t, tree.generateProofRaw(ckey, proof))
 
-	require.False(t, proof.verifyMembershipRaw(root, key))
+	require.False(t, proof.verifyMembershipRaw(DefaultHash, root, key))
 
-	require.True(t, proof.verifyNonMembershipRaw(root, key))
+	require.True(t, proof.verifyNonMembershipRaw(DefaultHash, root, key))
 
 }
 
@@ -125,8 +128,8 @@ func TestProveDeadend(t *testing.T) {
 	proof := NewProof()
 	require.NoError(t, tree.generateProofRaw(key, proof))
 
-	require.False(t, proof.verifyMembershipRaw(root, key))
-	require.True(t, proof.verifyNonMembershipRaw(root, key))
+	require.False(t, proof.verifyMembershipRaw(DefaultHash, root, key))
+	require.True(t, proof.verifyNonMembershipRaw(DefaultHash, root, key))
 
 	{
 		order := []byte{
@@ -207,3 +210,167 @@ func TestProveAfterDelete(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, proof.VerifyMembership(tree.hashSkipError(), key1))
 }
+
+// proofs from untrusted peers must never crash the decoder
+func TestProofUnmarshalHardened(t *testing.T) {
+	_, tree := setupDeterministicTree(t, 100)
+
+	var proofs [][]byte
+	for _, key := range [][]byte{[]byte("missing1"), []byte("missing2"), []byte("missing3")} {
+		proof, err := tree.GenerateProof(key)
+		require.NoError(t, err)
+		proofs = append(proofs, proof.Marshal())
+	}
+	key := []byte("member")
+	require.NoError(t, tree.Put(key, []byte("value")))
+	proof, err := tree.GenerateProof(key)
+	require.NoError(t, err)
+	proofs = append(proofs, proof.Marshal())
+
+	var decoded Proof
+	for _, buf := range proofs {
+		require.NoError(t, decoded.Unmarshal(buf))
+		require.Equal(t, buf, decoded.Marshal()) // encoding is canonical
+
+		for i := 0; i < len(buf); i++ {
+			err := decoded.Unmarshal(buf[:i])
+			require.True(t, xerrors.Is(err, ErrTruncatedProof), "length %d: %v", i, err)
+		}
+		require.True(t, xerrors.Is(decoded.Unmarshal(append(buf, 0)), ErrMalformedProof))
+
+		for i := 0; i < 2000; i++ { // random corruption must not panic
+			corrupted := append([]byte{}, buf...)
+			corrupted[rand.Intn(len(corrupted))] = byte(rand.Intn(256))
+			decoded.Unmarshal(corrupted)
+		}
+	}
+
+	buf := proofs[len(proofs)-1]
+	corrupted := append([]byte{}, buf...)
+	corrupted[0] = 2
+	require.True(t, xerrors.Is(decoded.Unmarshal(corrupted), ErrProofVersion))
+
+	corrupted = append([]byte{}, buf...)
+	corrupted[1] = 7
+	require.True(t, xerrors.Is(decoded.Unmarshal(corrupted), ErrMalformedProof))
+
+	// trace longer than a hash
+	var emptybits [HASHSIZE]byte
+	long := append([]byte{1, deadend, 0x81, 0x02}, emptybits[:]...)
+	require.True(t, xerrors.Is(decoded.Unmarshal(long), ErrMalformedProof))
+
+	// trace length which is not minimally encoded
+	padded := append([]byte{1, deadend, 0x81, 0x00}, emptybits[:]...)
+	require.True(t, xerrors.Is(decoded.Unmarshal(padded), ErrMalformedProof))
+	require.NoError(t, decoded.Unmarshal(append([]byte{1, deadend, 0x01}, emptybits[:]...)))
+
+	// trace bits beyond trace length
+	bits := emptybits
+	bits[HASHSIZE-1] = 1
+	require.True(t, xerrors.Is(decoded.Unmarshal(append([]byte{1, deadend, 0x01}, bits[:]...)), ErrMalformedProof))
+
+	// empty sibling sent as the empty hash would decode to the same proof as the canonical encoding
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	single, err := gv.GetTree("root")
+	require.NoError(t, err)
+	require.NoError(t, single.Put([]byte("key"), []byte("value")))
+	root := single.hashSkipError()
+	for i := 0; ; i++ {
+		missing := []byte(fmt.Sprintf("missing%d", i))
+		proof, err := single.GenerateProof(missing)
+		require.NoError(t, err)
+		if proof.p.Trace()[0] != nil { // missing key is on the other side of root
+			continue
+		}
+		require.True(t, proof.VerifyNonMembership(root, missing))
+		buf := proof.Marshal()
+		noncanonical := append([]byte{}, buf[:3+HASHSIZE]...)
+		noncanonical[3] |= 0x80
+		noncanonical = append(noncanonical, DefaultHash.zerosHash[:]...)
+		noncanonical = append(noncanonical, buf[3+HASHSIZE:]...)
+		require.True(t, xerrors.Is(decoded.Unmarshal(noncanonical), ErrMalformedProof))
+
+		// empty hashes of hash functions unknown to the decoder are rejected during verification
+		proof.p.Trace()[0] = DefaultHash.zerosHash[:]
+		require.False(t, proof.VerifyNonMembership(root, missing))
+		break
+	}
+}
+
+func TestProofWithValueHash(t *testing.T) {
+	_, tree := setupDeterministicTree(t, 100)
+	key, value := []byte("large"), make([]byte, 100*1024)
+	rand.Read(value)
+	require.NoError(t, tree.Put(key, value))
+	root := tree.hashSkipError()
+
+	full, err := tree.GenerateProof(key)
+	require.NoError(t, err)
+	proof, err := tree.GenerateProofWithValueHash(key)
+	require.NoError(t, err)
+
+	buf := proof.Marshal()
+	require.Less(t, len(buf)+len(value)/2, len(full.Marshal()))
+
+	var decoded Proof
+	require.NoError(t, decoded.Unmarshal(buf))
+	require.Equal(t, buf, decoded.Marshal())
+	require.True(t, decoded.VerifyMembershipWithValueHash(root, key))
+	require.False(t, decoded.VerifyMembership(root, key)) // value is not part of proof
+	require.False(t, decoded.VerifyNonMembership(root, key))
+	require.Empty(t, decoded.Value())
+
+	require.True(t, decoded.VerifyValue(value))
+	value[0] ^= 1
+	require.False(t, decoded.VerifyValue(value))
+	require.False(t, decoded.VerifyMembershipWithValueHash(root, []byte("other")))
+
+	// full proofs can be verified in both ways
+	require.True(t, full.VerifyMembershipWithValueHash(root, key))
+	require.False(t, full.VerifyValue(value))
+	value[0] ^= 1
+	require.True(t, full.VerifyValue(value))
+
+	// non member proofs are not changed
+	proof, err = tree.GenerateProofWithValueHash([]byte("missing"))
+	require.NoError(t, err)
+	require.True(t, proof.VerifyNonMembership(root, []byte("missing")))
+	require.False(t, proof.VerifyMembershipWithValueHash(root, []byte("missing")))
+	require.False(t, proof.VerifyValue(nil))
+
+	for i := 0; i < len(buf); i++ {
+		require.True(t, xerrors.Is(decoded.Unmarshal(buf[:i]), ErrTruncatedProof))
+	}
+}
+
+func TestProofWithValueHashSHA256(t *testing.T) {
+	store, err := NewMemStore(StoreOptions{Hash: HASH_SHA256})
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	require.NoError(t, tree.Put([]byte("key"), []byte("value")))
+
+	proof, err := tree.GenerateProofWithValueHash([]byte("key"))
+	require.NoError(t, err)
+	require.True(t, proof.VerifyMembershipWithValueHashUsing(HASH_SHA256, tree.hashSkipError(), []byte("key")))
+	require.False(t, proof.VerifyMembershipWithValueHash(tree.hashSkipError(), []byte("key")))
+	require.True(t, proof.VerifyValueUsing(HASH_SHA256, []byte("value")))
+	require.False(t, proof.VerifyValue([]byte("value")))
+}
+
+// proofs can be appended to a buffer which already holds data
+func TestProofMarshalTo(t *testing.T) {
+	_, tree := setupDeterministicTree(t, 100)
+	proof, err := tree.GenerateProof([]byte("missing"))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	b.WriteString("prefix")
+	proof.MarshalTo(&b)
+	require.Equal(t, proof.Marshal(), b.Bytes()[len("prefix"):])
+}
@@ -0,0 +1,182 @@
+package graviton
+
+import "fmt"
+import "bytes"
+
+// RangeProof proves the complete set of keys whose key hash starts with a prefix. It carries every leaf under the
+// prefix with its key and value, and the hashes of subtrees next to the path to the prefix. A verifier holding the
+// root hash can detect any key which has been omitted, added or changed.
+type RangeProof struct {
+	root *mpnode
+}
+
+// ProveRange generates a proof for all keys whose key hash has the first bits of prefix, bits can be 0 to 256
+func (t *Tree) ProveRange(prefix []byte, bits uint) (*RangeProof, error) {
+	if err := check_prefix(prefix, bits); err != nil {
+		return nil, err
+	}
+	root, err := t.rangeproof(t.root, prefix, bits, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeProof{root: root}, nil
+}
+
+func check_prefix(prefix []byte, bits uint) error {
+	if bits > HASHSIZE*8 {
+		return fmt.Errorf("invalid prefix bits %d", bits)
+	}
+	if uint(len(prefix))*8 < bits {
+		return fmt.Errorf("prefix of %d bytes is too short for %d bits", len(prefix), bits)
+	}
+	return nil
+}
+
+// whether first bits of keyhash are same as prefix
+func match_prefix(keyhash, prefix []byte, bits uint) bool {
+	for i := uint(0); i < bits; i++ {
+		if isBitSet(keyhash, i) != isBitSet(prefix, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// depth is the number of bits used to reach n
+func (t *Tree) rangeproof(n node, prefix []byte, bits, depth uint) (*mpnode, error) {
+	switch v := n.(type) {
+	case nil:
+		return &mpnode{kind: mp_empty}, nil
+
+	case *leaf:
+		if err := v.load_partial(t.store); err != nil {
+			return nil, err
+		}
+		if match_prefix(v.keyhash[:], prefix, bits) {
+			return &mpnode{kind: mp_keyvalue, key: append([]byte{}, v.key...), value: append([]byte{}, v.value...)}, nil
+		}
+		valuehash := t.store.hash.sum(v.value) // leaf ends the path but is outside the range
+		return &mpnode{kind: mp_leaf, keyhash: append([]byte{}, v.keyhash[:]...), hash: valuehash[:]}, nil
+
+	case *inner:
+		if err := v.load_partial(t.store); err != nil {
+			return nil, err
+		}
+		if depth >= bits { // within range, everything is expanded
+			left, err := t.rangeproof(v.left, prefix, bits, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			right, err := t.rangeproof(v.right, prefix, bits, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			return &mpnode{kind: mp_inner, left: left, right: right}, nil
+		}
+
+		// on the path to the range, only the sibling hash is required
+		onpath, sibling := v.left, v.right
+		if isBitSet(prefix, depth) {
+			onpath, sibling = v.right, v.left
+		}
+		path, err := t.rangeproof(onpath, prefix, bits, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := t.multiproof(sibling, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		if isBitSet(prefix, depth) {
+			return &mpnode{kind: mp_inner, left: hash, right: path}, nil
+		}
+		return &mpnode{kind: mp_inner, left: path, right: hash}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown node type")
+	}
+}
+
+// Verify the proof against a root built with default hash function and return all keys,values in the range in hash order
+// an error is returned if the proof does not cover the complete range
+func (rp *RangeProof) Verify(root [HASHSIZE]byte, prefix []byte, bits uint) (keys, values [][]byte, err error) {
+	return rp.verify(DefaultHash, root, prefix, bits)
+}
+
+// VerifyUsing returns all keys and values under prefix, checked against a root of a store created with the named
+// hash function
+func (rp *RangeProof) VerifyUsing(hashname string, root [HASHSIZE]byte, prefix []byte, bits uint) (keys, values [][]byte, err error) {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rp.verify(hf, root, prefix, bits)
+}
+
+func (rp *RangeProof) verify(hf *HashFunction, root [HASHSIZE]byte, prefix []byte, bits uint) (keys, values [][]byte, err error) {
+	if err = check_prefix(prefix, bits); err != nil {
+		return
+	}
+	if rp.root == nil {
+		return nil, nil, fmt.Errorf("empty range proof")
+	}
+	if !bytes.Equal(rp.root.roothash(hf), root[:]) {
+		return nil, nil, fmt.Errorf("range proof does not match root")
+	}
+
+	var walk func(n *mpnode, depth uint) error
+	walk = func(n *mpnode, depth uint) error {
+		switch n.kind {
+		case mp_empty:
+		case mp_hash:
+			return fmt.Errorf("range proof omits a subtree at depth %d", depth)
+		case mp_inner:
+			if depth >= bits {
+				if err := walk(n.left, depth+1); err != nil {
+					return err
+				}
+				return walk(n.right, depth+1)
+			}
+			if isBitSet(prefix, depth) {
+				return walk(n.right, depth+1)
+			}
+			return walk(n.left, depth+1)
+		case mp_leaf:
+			if match_prefix(n.keyhash, prefix, bits) {
+				return fmt.Errorf("range proof omits value of key hash %x", n.keyhash)
+			}
+		case mp_keyvalue:
+			keyhash := hf.sum(n.key)
+			if match_prefix(keyhash[:], prefix, bits) {
+				keys = append(keys, append([]byte{}, n.key...))
+				values = append(values, append([]byte{}, n.value...))
+			} else if depth > bits { // leaves below the prefix always match it
+				return fmt.Errorf("range proof has key hash %x outside range", keyhash)
+			}
+		default:
+			return fmt.Errorf("invalid range proof node kind %d", n.kind)
+		}
+		return nil
+	}
+	if err = walk(rp.root, 0); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// Serialize the range proof to a byte array, format is same as multiproof
+func (rp *RangeProof) Marshal() []byte {
+	var b bytes.Buffer
+	b.WriteByte(1) // write version
+	if rp.root != nil {
+		rp.root.marshal(&b)
+	}
+	return b.Bytes()
+}
+
+// Unmarshal deserializes a range proof for verification, see MultiProof.Unmarshal
+func (rp *RangeProof) Unmarshal(buf []byte) error {
+	root, err := unmarshal_mproot(buf, mp_range_kinds)
+	rp.root = root
+	return err
+}
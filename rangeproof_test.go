@@ -0,0 +1,145 @@
+package graviton
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeProof(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, tree.Commit())
+	root := tree.hashSkipError()
+
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+
+	prefix := []byte{0xa5, 0x5a}
+	for bits := uint(0); bits <= 16; bits++ {
+		// collect expected keys using the cursor
+		expected := map[string]string{}
+		c := tree.Cursor()
+		for k, v, err := c.First(); err == nil; k, v, err = c.Next() {
+			keyhash := Sum(k)
+			if match_prefix(keyhash[:], prefix, bits) {
+				expected[string(k)] = string(v)
+			}
+		}
+
+		rp, err := tree.ProveRange(prefix, bits)
+		require.NoError(t, err)
+
+		var decoded RangeProof
+		require.NoError(t, decoded.Unmarshal(rp.Marshal()))
+		keys, values, err := decoded.Verify(root, prefix, bits)
+		require.NoError(t, err)
+		require.Len(t, keys, len(expected), "bits %d", bits)
+		for i := range keys {
+			require.Equal(t, expected[string(keys[i])], string(values[i]))
+		}
+	}
+
+	// proof of a smaller range does not prove a larger one
+	rp, err := tree.ProveRange(prefix, 8)
+	require.NoError(t, err)
+	_, _, err = rp.Verify(root, prefix, 4)
+	require.Error(t, err)
+
+	// withholding a key is detected
+	rp, err = tree.ProveRange(prefix, 4)
+	require.NoError(t, err)
+	keys, _, err := rp.Verify(root, prefix, 4)
+	require.NoError(t, err)
+	require.NotEmpty(t, keys)
+
+	withhold := func(n *mpnode, hf *HashFunction) *mpnode {
+		var walk func(n *mpnode) bool
+		walk = func(n *mpnode) bool {
+			for _, child := range []**mpnode{&n.left, &n.right} {
+				switch (*child).kind {
+				case mp_keyvalue:
+					hash := (*child).roothash(hf)
+					*child = &mpnode{kind: mp_hash, hash: hash}
+					return true
+				case mp_inner:
+					if walk(*child) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		require.True(t, walk(n))
+		return n
+	}
+	rp.root = withhold(rp.root, DefaultHash)
+	_, _, err = rp.Verify(root, prefix, 4)
+	require.Error(t, err) // root still matches, but range is incomplete
+
+	// wrong root, bad prefix and corrupted proofs
+	rp, err = tree.ProveRange(prefix, 4)
+	require.NoError(t, err)
+	root[1] ^= 1
+	_, _, err = rp.Verify(root, prefix, 4)
+	require.Error(t, err)
+	_, err = tree.ProveRange(prefix, 17)
+	require.Error(t, err)
+	_, err = tree.ProveRange(prefix, 257)
+	require.Error(t, err)
+
+	var decoded RangeProof
+	buf := rp.Marshal()
+	require.Error(t, decoded.Unmarshal(buf[:len(buf)-1]))
+	require.Error(t, decoded.Unmarshal(append(buf, 0)))
+	require.Error(t, decoded.Unmarshal([]byte{1, mp_value}))
+}
+
+func TestRangeProofSmallTree(t *testing.T) {
+	store, err := NewMemStore(StoreOptions{Hash: HASH_SHA256})
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	prefix := make([]byte, HASHSIZE)
+	for _, bits := range []uint{0, 8, 256} { // empty tree
+		rp, err := tree.ProveRange(prefix, bits)
+		require.NoError(t, err)
+		keys, _, err := rp.VerifyUsing(HASH_SHA256, tree.hashSkipError(), prefix, bits)
+		require.NoError(t, err)
+		require.Empty(t, keys)
+	}
+
+	// single key, the leaf is directly below root
+	require.NoError(t, tree.Put([]byte("key"), []byte("value")))
+	keyhash := tree.store.hash.sum([]byte("key"))
+	for _, bits := range []uint{1, 8, 256} {
+		rp, err := tree.ProveRange(keyhash[:], bits)
+		require.NoError(t, err)
+		keys, values, err := rp.VerifyUsing(HASH_SHA256, tree.hashSkipError(), keyhash[:], bits)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{[]byte("key")}, keys)
+		require.Equal(t, [][]byte{[]byte("value")}, values)
+
+		other := append([]byte{}, keyhash[:]...)
+		other[(bits-1)/8] ^= 0x80 >> ((bits - 1) % 8)
+		rp, err = tree.ProveRange(other, bits)
+		require.NoError(t, err)
+		keys, _, err = rp.VerifyUsing(HASH_SHA256, tree.hashSkipError(), other, bits)
+		require.NoError(t, err)
+		require.Empty(t, keys)
+	}
+}
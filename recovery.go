@@ -0,0 +1,144 @@
+package graviton
+
+import "os"
+import "fmt"
+import "bytes"
+
+import "golang.org/x/xerrors"
+
+// RecoveryReport describes the result of validating version records while opening a disk store.
+// A crash during commit may leave version records at the end of version_root.bin which point past the end of
+// written data, such a torn tail is truncated so as LoadSnapshot(0) always lands on a consistent snapshot.
+// Any other failure refuses to open the store, unless StoreOptions.Repair is set, in which case all versions after
+// the highest intact version are discarded.
+type RecoveryReport struct {
+	HighestVersion    uint64  // highest version record found in version_root.bin
+	ValidVersion      uint64  // highest version which passed validation, store now starts from here
+	DiscardedVersions uint64  // number of version records truncated
+	PartialBytes      int64   // bytes of a torn partial record which were truncated
+	Errors            []error // reason for discarding each version, highest version first
+}
+
+// whether anything was repaired while opening the store
+func (r RecoveryReport) Repaired() bool {
+	return r.DiscardedVersions > 0 || r.PartialBytes > 0
+}
+
+// RecoveryReport returns the report of the validation done while the store was opened
+func (s *Store) RecoveryReport() RecoveryReport {
+	return s.recovery
+}
+
+// scan back from the highest version, skipping records of a torn tail, the first remaining version must be intact
+// records after it are truncated, nothing is modified if the store cannot be opened
+func (s *Store) recover() error {
+	fstat, err := s.disk.versionrootfile.Stat()
+	if err != nil {
+		return err
+	}
+
+	report := RecoveryReport{HighestVersion: uint64(fstat.Size() / 8), PartialBytes: fstat.Size() % 8}
+
+	for version := report.HighestVersion; version >= 1; version-- {
+		findex, fpos, err := s.ReadVersionData(version)
+		if err != nil {
+			return err
+		}
+		torn, err := s.torn_record(findex, fpos)
+		if err == nil && !torn {
+			if err = s.verify_version_root(findex, fpos); err == nil {
+				report.ValidVersion = version
+				break
+			}
+		}
+		if err != nil && !s.options.Repair {
+			return xerrors.Errorf("%w: version %d: %s, open with Repair option to discard it and all later versions", ErrCorruption, version, err)
+		}
+		if torn {
+			err = fmt.Errorf("findex %d fpos %d is beyond written data", findex, fpos)
+		}
+		report.Errors = append(report.Errors, fmt.Errorf("version %d: %s", version, err))
+	}
+	report.DiscardedVersions = report.HighestVersion - report.ValidVersion
+	s.recovery = report
+
+	if !report.Repaired() {
+		return nil
+	}
+	if err = s.disk.versionrootfile.Truncate(int64(report.ValidVersion * 8)); err != nil {
+		return err
+	}
+	return s.disk.versionrootfile.Sync()
+}
+
+// whether a version record points past the end of newest data file, as left by a crash during commit
+// zero records are left when version_root.bin was extended but the record itself never reached the disk
+func (s *Store) torn_record(findex, fpos uint32) (bool, error) {
+	if findex == 0 && fpos == 0 {
+		return true, nil
+	}
+	d := s.disk
+	if findex > d.findex { // newest file may never have reached the disk, but no file may be missing before it
+		for i := d.findex + 1; i <= findex+1; i++ {
+			if _, err := os.Stat(d.uint_to_filename(i)); !os.IsNotExist(err) {
+				return false, fmt.Errorf("data file %d is missing", d.findex+1)
+			}
+		}
+		return true, nil
+	}
+	if findex < d.findex {
+		return false, nil
+	}
+
+	size := d.files[findex].size
+	if fpos >= size {
+		return true, nil
+	}
+	var length [1]byte // inner nodes are prepended with their length
+	if _, err := s.read(findex, fpos, length[:]); err != nil {
+		return false, err
+	}
+	return uint32(length[0]) > size-fpos, nil
+}
+
+// verify that version root parses correctly and its children are present and match their hashes
+func (s *Store) verify_version_root(findex, fpos uint32) error {
+	if findex == 0 && fpos == 0 {
+		return fmt.Errorf("invalid position findex %d fpos %d", findex, fpos)
+	}
+	if cfile, ok := s.disk.files[findex]; !ok || fpos >= cfile.size {
+		return fmt.Errorf("position findex %d fpos %d is beyond written data", findex, fpos)
+	}
+
+	_, vroot, err := s.loadrootusingpos(findex, fpos)
+	if err != nil {
+		return err
+	}
+	if vroot == nil {
+		return fmt.Errorf("root node could not be read")
+	}
+
+	for _, child := range []node{vroot.left, vroot.right} {
+		switch v := child.(type) {
+		case nil:
+		case *leaf: // leaves verify themselves while loading
+			if err = v.load_partial(s); err != nil {
+				return err
+			}
+		case *inner: // recompute hash of inner node from its children hashes
+			expected := append([]byte{}, v.hash...)
+			if err = v.load_partial(s); err != nil {
+				return err
+			}
+			v.hash = v.hash[:0]
+			hash, err := v.Hash(s)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(hash, expected) {
+				return fmt.Errorf("%w: inner node hash mismatch findex %d fpos %d", ErrCorruption, v.findex, v.fpos)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package graviton
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_recovery")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	require.False(t, store.RecoveryReport().Repaired())
+
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	var roothashes [][HASHSIZE]byte
+	for i := 0; i < 5; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+		require.NoError(t, tree.Commit())
+		roothashes = append(roothashes, tree.hashSkipError())
+	}
+	findex, fpos, err := store.ReadVersionData(5)
+	require.NoError(t, err)
+	store.Close()
+
+	// simulate a torn write, last root node is partially written and version record is torn
+	datafile := filepath.Join(dir, "0", "0", "0", fmt.Sprintf("%d.dfs", findex))
+	require.NoError(t, os.Truncate(datafile, int64(fpos)+2))
+	vfile, err := os.OpenFile(filepath.Join(dir, "version_root.bin"), os.O_RDWR|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = vfile.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	vfile.Close()
+
+	store, err = NewDiskStore(dir)
+	require.NoError(t, err)
+	report := store.RecoveryReport()
+	require.True(t, report.Repaired())
+	require.Equal(t, uint64(5), report.HighestVersion)
+	require.Equal(t, uint64(4), report.ValidVersion)
+	require.Equal(t, uint64(1), report.DiscardedVersions)
+	require.Equal(t, int64(3), report.PartialBytes)
+	require.Equal(t, 1, len(report.Errors))
+
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), gv.GetVersion())
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	require.Equal(t, roothashes[3], tree.hashSkipError())
+
+	// store must be usable after recovery
+	require.NoError(t, tree.Put([]byte("newkey"), []byte("value")))
+	require.NoError(t, tree.Commit())
+	store.Close()
+
+	// a record pointing beyond written data is discarded
+	vfile, err = os.OpenFile(filepath.Join(dir, "version_root.bin"), os.O_RDWR|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = vfile.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0x0f})
+	require.NoError(t, err)
+	_, err = vfile.Write(make([]byte, 8)) // zero filled record
+	require.NoError(t, err)
+	vfile.Close()
+
+	store, err = NewDiskStore(dir)
+	require.NoError(t, err)
+	report = store.RecoveryReport()
+	require.Equal(t, uint64(5), report.ValidVersion)
+	require.Equal(t, uint64(2), report.DiscardedVersions)
+	finfo, err := os.Stat(filepath.Join(dir, "version_root.bin"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5*8), finfo.Size())
+}
+
+func TestRecoveryRefusesCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_recovery")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ { // every version lands in a new data file
+		store.disk.files[store.disk.findex].size = MAX_FILE_SIZE - 64
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+		require.NoError(t, tree.Commit())
+	}
+	require.Equal(t, uint32(3), store.disk.findex)
+	store.Close()
+
+	vfilename := filepath.Join(dir, "version_root.bin")
+	records, err := ioutil.ReadFile(vfilename)
+	require.NoError(t, err)
+
+	// a missing data file in the middle is not a torn tail, nothing must be truncated
+	middle := filepath.Join(dir, "0", "0", "0", "2.dfs")
+	require.NoError(t, os.Rename(middle, middle+".moved"))
+	_, err = NewDiskStore(dir)
+	require.True(t, xerrors.Is(err, ErrCorruption))
+	unchanged, err := ioutil.ReadFile(vfilename)
+	require.NoError(t, err)
+	require.Equal(t, records, unchanged)
+	require.NoError(t, os.Rename(middle+".moved", middle))
+
+	// a corrupted root within written data is refused too, unless repair is requested
+	findex, fpos := binary.LittleEndian.Uint32(records[16:]), binary.LittleEndian.Uint32(records[20:])
+	datafile, err := os.OpenFile(filepath.Join(dir, "0", "0", "0", fmt.Sprintf("%d.dfs", findex)), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = datafile.WriteAt([]byte{0xff}, int64(fpos)+4)
+	require.NoError(t, err)
+	datafile.Close()
+
+	_, err = NewDiskStore(dir)
+	require.True(t, xerrors.Is(err, ErrCorruption))
+	unchanged, err = ioutil.ReadFile(vfilename)
+	require.NoError(t, err)
+	require.Equal(t, records, unchanged)
+
+	store, err = NewDiskStore(dir, StoreOptions{Repair: true})
+	require.NoError(t, err)
+	report := store.RecoveryReport()
+	require.Equal(t, uint64(2), report.ValidVersion)
+	require.Equal(t, uint64(1), report.DiscardedVersions)
+	store.Close()
+}
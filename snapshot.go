@@ -3,6 +3,8 @@ package graviton
 import "fmt"
 import "encoding/binary"
 
+import "golang.org/x/xerrors"
+
 // 		Snapshot are used to access any arbitrary snapshot of entire database at any point in time
 // 		snapshot refers to collective state of all trees + data (key-values) + history
 // 		each commit ( tree.Commit() or Commit(tree1, tree2 .....)) creates a new snapshot
@@ -44,6 +46,9 @@ func (store *Store) LoadSnapshot(version uint64) (*Snapshot, error) {
 	if findex, fpos, err = store.ReadVersionData(version); err != nil {
 		return nil, err
 	}
+	if findex == 0 && fpos == 0 { // version was dropped by compaction
+		return nil, xerrors.Errorf("%w: version %d", ErrVersionNotStored, version)
+	}
 	_, vroot, err := store.loadrootusingpos(findex, fpos)
 	if err != nil {
 		return nil, err
@@ -75,7 +80,7 @@ func (s *Snapshot) loadTree(key []byte) (tree *Tree, err error) {
 	var bname string
 	var root *inner
 	var position []byte
-	if position, err = s.vroot.Get(s.store, sum(key)); err == nil { // underscore is first character
+	if position, err = s.vroot.Get(s.store, s.store.hash.sum(key)); err == nil { // underscore is first character
 
 		if bname, root, err = s.store.loadrootusingpos(decode(position)); err == nil {
 			tree = &Tree{store: s.store, root: root, treename: bname, snapshot_version: s.version}
@@ -120,7 +125,7 @@ func (s *Snapshot) GetTreeHighestVersion(treename string) (uint64, error) {
 	done := 1
 	done += copy(buf[done:], []byte(treename))
 
-	vversion, err := s.vroot.Get(s.store, sum(buf[:done]))
+	vversion, err := s.vroot.Get(s.store, s.store.hash.sum(buf[:done]))
 	if err != nil { // return no found
 		return 0, nil // fmt.Errorf("version is not stored")
 	}
@@ -177,6 +182,6 @@ func (s *Snapshot) putTreeHighestVersion(treename string, version uint64) error
 	done += copy(buf[done:], []byte(treename))
 	valuesize := binary.PutUvarint(value[:], version)
 
-	leaf := newLeaf(sum(buf[:done]), buf[:done], value[:valuesize])
+	leaf := newLeaf(s.store, s.store.hash.sum(buf[:done]), buf[:done], value[:valuesize])
 	return s.vroot.Insert(s.store, leaf)
 }
@@ -121,7 +121,7 @@ func TestGetTreeHighestVersion(t *testing.T) {
 
 	var faulty_uvarint = [12]byte{0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88}
 
-	gv.vroot.Insert(gv.store, newLeaf(sum([]byte(colonname)), []byte(colonname), faulty_uvarint[:])) // we have inserted faulty data,
+	gv.vroot.Insert(gv.store, newLeaf(gv.store, gv.store.hash.sum([]byte(colonname)), []byte(colonname), faulty_uvarint[:])) // we have inserted faulty data,
 	// lets call back and check whether its detected
 
 	_, err = gv.GetTreeHighestVersion("root")
@@ -136,7 +136,7 @@ func TestGetTreeHighestVersion(t *testing.T) {
 	require.NoError(t, err)
 
 	encode(findex, fpos, faulty_inner[:]) // inject this into vroot
-	gv.vroot.Insert(gv.store, newLeaf(sum([]byte(colonname)), []byte(colonname), faulty_inner[:]))
+	gv.vroot.Insert(gv.store, newLeaf(gv.store, gv.store.hash.sum([]byte(colonname)), []byte(colonname), faulty_inner[:]))
 	// lets call back and check whether its detected
 	_, err = gv.loadTree([]byte(colonname))
 
@@ -182,8 +182,8 @@ func TestLoadSnapshot(t *testing.T) {
 
 	//fmt.Printf("error %s\n", store.loadsnapshottablestoram())
 
-	store.versionrootfile.memoryfile[(loop_count-1)*8+7] = 1 // corrupt last entry
-	store.versionrootfile.memoryfile[(loop_count-2)*8+7] = 1 // corrupt second last entry
+	store.backend.(*memoryBackend).versions[(loop_count-1)*8+7] = 1 // corrupt last entry
+	store.backend.(*memoryBackend).versions[(loop_count-2)*8+7] = 1 // corrupt second last entry
 
 	_, err = store.LoadSnapshot(0) // trigger recent version corruption
 	require.Error(t, err)
@@ -0,0 +1,214 @@
+package graviton
+
+import "bytes"
+import "encoding/binary"
+
+import "golang.org/x/xerrors"
+
+import "github.com/deroproject/graviton/verifier"
+
+// SnapshotProof proves a key of a named tree against the hash of a snapshot, so as a single hash per snapshot
+// covers all trees. It carries a proof of the tree name in the tree of tree roots ( see Snapshot.Hash ), followed
+// by a regular proof of the key against the tree root hash.
+type SnapshotProof struct {
+	present  bool           // whether the tree exists in the snapshot
+	treeroot [HASHSIZE]byte // root hash of the tree
+	trees    Proof          // proof of tree name in the tree of tree roots
+	key      Proof          // proof of key in the tree
+}
+
+// Hash of the snapshot, it is the root hash of a tree mapping name of every tree in the snapshot to the root hash of
+// its most recent version. It only depends on tree names and contents, so it is same for every store holding same
+// trees and does not change on compaction or restore. The version root is walked to build it.
+func (s *Snapshot) Hash() (h [HASHSIZE]byte, err error) {
+	roots, err := s.tree_roots()
+	if err != nil {
+		return
+	}
+	return roots.Hash()
+}
+
+// build the tree of tree roots in memory, it is never committed
+func (s *Snapshot) tree_roots() (*Tree, error) {
+	roots := &Tree{store: s.store, root: newInner(0), snapshot_version: s.version}
+	cursor := (&Tree{store: s.store, root: s.vroot}).Cursor()
+	k, v, err := cursor.First()
+	for ; err == nil; k, v, err = cursor.Next() {
+		treename, ok := tree_version_entry(k, v)
+		if !ok {
+			continue
+		}
+		version, _ := binary.Uvarint(v)
+		tree, err := s.GetTreeWithVersion(treename, version)
+		if err != nil {
+			return nil, err
+		}
+		roothash, err := tree.Hash()
+		if err != nil {
+			return nil, err
+		}
+		if err = roots.Put([]byte(treename), roothash[:]); err != nil {
+			return nil, err
+		}
+	}
+	if err != ErrNoMoreKeys {
+		return nil, err
+	}
+	return roots, nil
+}
+
+// GenerateProof generates a proof for a key of the most recent version of tree in this snapshot, keys of trees which
+// do not exist in the snapshot can be proved as non-members
+func (s *Snapshot) GenerateProof(treename string, key []byte) (*SnapshotProof, error) {
+	if err := check_tree_name(treename); err != nil {
+		return nil, err
+	}
+	roots, err := s.tree_roots()
+	if err != nil {
+		return nil, err
+	}
+
+	var sp SnapshotProof
+	if err = roots.generateProofRaw(s.store.hash.sum([]byte(treename)), &sp.trees); err != nil {
+		return nil, err
+	}
+	if sp.trees.ptype() != member { // tree does not exist
+		return &sp, nil
+	}
+
+	tree, err := s.GetTree(treename)
+	if err != nil {
+		return nil, err
+	}
+	if err = tree.generateProofRaw(s.store.hash.sum(key), &sp.key); err != nil {
+		return nil, err
+	}
+	sp.present = true
+	copy(sp.treeroot[:], sp.trees.Value())
+	return &sp, nil
+}
+
+// verify the tree name against the snapshot hash, returns whether the tree exists
+func (sp *SnapshotProof) verifyTree(hf *HashFunction, snapshothash [HASHSIZE]byte, treename string) (bool, error) {
+	if err := check_tree_name(treename); err != nil {
+		return false, err
+	}
+	namehash := hf.sum([]byte(treename))
+	if !sp.present {
+		if !sp.trees.verifyNonMembershipRaw(hf, snapshothash, namehash) {
+			return false, xerrors.Errorf("%w: tree %s is not proved missing", ErrMalformedProof, treename)
+		}
+		return false, nil
+	}
+	if !bytes.Equal(sp.trees.Value(), sp.treeroot[:]) || !sp.trees.verifyMembershipRaw(hf, snapshothash, namehash) {
+		return false, xerrors.Errorf("%w: tree %s root is not proved", ErrMalformedProof, treename)
+	}
+	return true, nil
+}
+
+// verify membership of key of the named tree in a snapshot of a store using default hash function
+func (sp *SnapshotProof) VerifyMembership(snapshothash [HASHSIZE]byte, treename string, key []byte) bool {
+	return sp.verifyRaw(DefaultHash, snapshothash, treename, key, true)
+}
+
+// VerifyMembershipUsing verifies that key is in the named tree of a snapshot, for stores created with the named
+// hash function. Both the tree root against the snapshot hash and the key against the tree root are checked
+func (sp *SnapshotProof) VerifyMembershipUsing(hashname string, snapshothash [HASHSIZE]byte, treename string, key []byte) bool {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return false
+	}
+	return sp.verifyRaw(hf, snapshothash, treename, key, true)
+}
+
+// verify non membership of key of the named tree in a snapshot of a store using default hash function
+func (sp *SnapshotProof) VerifyNonMembership(snapshothash [HASHSIZE]byte, treename string, key []byte) bool {
+	return sp.verifyRaw(DefaultHash, snapshothash, treename, key, false)
+}
+
+// VerifyNonMembershipUsing verifies that key is not in the named tree of a snapshot of a store created with the
+// named hash function, keys of trees missing from the snapshot are absent as well
+func (sp *SnapshotProof) VerifyNonMembershipUsing(hashname string, snapshothash [HASHSIZE]byte, treename string, key []byte) bool {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return false
+	}
+	return sp.verifyRaw(hf, snapshothash, treename, key, false)
+}
+
+func (sp *SnapshotProof) verifyRaw(hf *HashFunction, snapshothash [HASHSIZE]byte, treename string, key []byte, member bool) bool {
+	present, err := sp.verifyTree(hf, snapshothash, treename)
+	if err != nil {
+		return false
+	}
+	if !present { // keys of missing trees do not exist
+		return !member
+	}
+	if member {
+		return sp.key.verifyMembershipRaw(hf, sp.treeroot, hf.sum(key))
+	}
+	return sp.key.verifyNonMembershipRaw(hf, sp.treeroot, hf.sum(key))
+}
+
+// value of key if the proof is for existence of a key
+func (sp *SnapshotProof) Value() []byte {
+	return sp.key.Value()
+}
+
+// root hash of the tree, only valid once the proof is verified
+func (sp *SnapshotProof) TreeRoot() [HASHSIZE]byte {
+	return sp.treeroot
+}
+
+// Serialize the snapshot proof to a byte array
+//
+//	1 byte version
+//	varint length prefixed proof of tree name in the tree of tree roots
+//	if tree exists, proof of key follows, the tree root hash is the value proved by the tree name proof
+func (sp *SnapshotProof) Marshal() []byte {
+	var b bytes.Buffer
+	var buf [binary.MaxVarintLen64]byte
+	b.WriteByte(1) // write version
+	trees := sp.trees.Marshal()
+	b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(trees)))])
+	b.Write(trees)
+	if sp.present {
+		sp.key.MarshalTo(&b)
+	}
+	return b.Bytes()
+}
+
+// Unmarshal deserializes a snapshot proof, errors wrap ErrProofVersion, ErrTruncatedProof or ErrMalformedProof
+func (sp *SnapshotProof) Unmarshal(buf []byte) (err error) {
+	*sp = SnapshotProof{}
+	if len(buf) < 1 {
+		return xerrors.Errorf("%w: %d bytes", ErrTruncatedProof, len(buf))
+	}
+	if buf[0] != 1 {
+		return xerrors.Errorf("%w: %d", ErrProofVersion, buf[0])
+	}
+
+	trees, done, err := verifier.ReadBytes(buf, 1, MAX_VALUE_SIZE)
+	if err != nil {
+		return err
+	}
+	if err = sp.trees.Unmarshal(trees); err != nil {
+		return err
+	}
+	switch sp.trees.ptype() {
+	case member:
+		if len(sp.trees.Value()) != HASHSIZE {
+			return xerrors.Errorf("%w: tree root is %d bytes", ErrMalformedProof, len(sp.trees.Value()))
+		}
+	case collision, deadend:
+		if done != len(buf) {
+			return xerrors.Errorf("%w: %d extra bytes", ErrMalformedProof, len(buf)-done)
+		}
+		return nil
+	default: // tree root hash must be carried
+		return xerrors.Errorf("%w: tree proof type %d", ErrMalformedProof, sp.trees.ptype())
+	}
+	sp.present = true
+	copy(sp.treeroot[:], sp.trees.Value())
+	return sp.key.Unmarshal(buf[done:])
+}
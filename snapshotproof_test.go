@@ -0,0 +1,181 @@
+package graviton
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestSnapshotProof(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree1, err := gv.GetTree("tree1")
+	require.NoError(t, err)
+	tree2, err := gv.GetTree("tree2")
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, tree1.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+		require.NoError(t, tree2.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("other%d", i))))
+	}
+	_, err = Commit(tree1, tree2)
+	require.NoError(t, err)
+	require.NoError(t, tree1.Put([]byte("key0"), []byte("changed")))
+	require.NoError(t, tree1.Commit())
+
+	old, err := store.LoadSnapshot(1)
+	require.NoError(t, err)
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	hash, err := gv.Hash()
+	require.NoError(t, err)
+	oldhash, err := old.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, oldhash)
+
+	verify := func(ss *Snapshot, hash [HASHSIZE]byte, treename string, key []byte) *SnapshotProof {
+		proof, err := ss.GenerateProof(treename, key)
+		require.NoError(t, err)
+		var decoded SnapshotProof
+		buf := proof.Marshal()
+		require.NoError(t, decoded.Unmarshal(buf))
+		require.Equal(t, buf, decoded.Marshal())
+		for i := 0; i < len(buf); i++ {
+			require.True(t, xerrors.Is(decoded.Unmarshal(buf[:i]), ErrTruncatedProof))
+		}
+		require.NoError(t, decoded.Unmarshal(buf))
+		return &decoded
+	}
+
+	proof := verify(gv, hash, "tree1", []byte("key0"))
+	require.True(t, proof.VerifyMembership(hash, "tree1", []byte("key0")))
+	require.False(t, proof.VerifyNonMembership(hash, "tree1", []byte("key0")))
+	require.Equal(t, []byte("changed"), proof.Value())
+	require.Equal(t, tree1.hashSkipError(), proof.TreeRoot())
+	require.False(t, proof.VerifyMembership(oldhash, "tree1", []byte("key0")))
+	require.False(t, proof.VerifyMembership(hash, "tree2", []byte("key0")))
+	require.False(t, proof.VerifyMembership(hash, "tree1", []byte("key1")))
+
+	proof = verify(old, oldhash, "tree1", []byte("key0"))
+	require.True(t, proof.VerifyMembership(oldhash, "tree1", []byte("key0")))
+	require.Equal(t, []byte("value0"), proof.Value())
+
+	proof = verify(gv, hash, "tree2", []byte("missing"))
+	require.True(t, proof.VerifyNonMembership(hash, "tree2", []byte("missing")))
+	require.False(t, proof.VerifyMembership(hash, "tree2", []byte("missing")))
+
+	// keys of trees which do not exist
+	proof = verify(gv, hash, "tree3", []byte("key0"))
+	require.True(t, proof.VerifyNonMembership(hash, "tree3", []byte("key0")))
+	require.False(t, proof.VerifyMembership(hash, "tree3", []byte("key0")))
+	require.False(t, proof.VerifyNonMembership(hash, "tree1", []byte("key0")))
+
+	// proof of a tree root which is not the latest version of the tree
+	proof = verify(old, oldhash, "tree1", []byte("key0"))
+	current := verify(gv, hash, "tree1", []byte("key0"))
+	proof.trees = current.trees
+	require.False(t, proof.VerifyMembership(hash, "tree1", []byte("key0")))
+
+	_, err = gv.GenerateProof(":invalid", []byte("key0"))
+	require.Error(t, err)
+}
+
+func TestSnapshotProofHash(t *testing.T) {
+	store, err := NewMemStore(StoreOptions{Hash: HASH_SHA256})
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("tree")
+	require.NoError(t, err)
+	require.NoError(t, tree.Put([]byte("key"), []byte("value")))
+	require.NoError(t, tree.Commit())
+
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	hash, err := gv.Hash()
+	require.NoError(t, err)
+	proof, err := gv.GenerateProof("tree", []byte("key"))
+	require.NoError(t, err)
+	require.True(t, proof.VerifyMembershipUsing(HASH_SHA256, hash, "tree", []byte("key")))
+	require.False(t, proof.VerifyMembership(hash, "tree", []byte("key")))
+	require.False(t, proof.VerifyNonMembershipUsing(HASH_SHA256, hash, "tree", []byte("other"))) // proof is for another key
+
+	proof, err = gv.GenerateProof("tree", []byte("other"))
+	require.NoError(t, err)
+	require.True(t, proof.VerifyNonMembershipUsing(HASH_SHA256, hash, "tree", []byte("other")))
+}
+
+// snapshot hash depends only on tree names and contents
+func TestSnapshotHashContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graviton_snapshothash")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // clean up
+
+	store, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree1, err := gv.GetTree("tree1")
+	require.NoError(t, err)
+	require.NoError(t, tree1.Put([]byte("a"), []byte("1")))
+	require.NoError(t, tree1.Put([]byte("b"), []byte("2")))
+	require.NoError(t, tree1.Commit())
+	tree2, err := gv.GetTree("tree2")
+	require.NoError(t, err)
+	require.NoError(t, tree2.Put([]byte("c"), []byte("3")))
+	require.NoError(t, tree2.Commit())
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree1, err = gv.GetTree("tree1")
+	require.NoError(t, err)
+	require.NoError(t, tree1.Put([]byte("a"), []byte("changed")))
+	tree1.Tags = []string{"tagged"}
+	require.NoError(t, tree1.Commit())
+
+	// same trees built by a single commit in another store
+	other, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err = other.LoadSnapshot(0)
+	require.NoError(t, err)
+	otree1, err := gv.GetTree("tree1")
+	require.NoError(t, err)
+	otree2, err := gv.GetTree("tree2")
+	require.NoError(t, err)
+	require.NoError(t, otree1.Put([]byte("b"), []byte("2")))
+	require.NoError(t, otree1.Put([]byte("a"), []byte("changed")))
+	require.NoError(t, otree2.Put([]byte("c"), []byte("3")))
+	_, err = Commit(otree1, otree2)
+	require.NoError(t, err)
+
+	snapshothash := func(store *Store) [HASHSIZE]byte {
+		gv, err := store.LoadSnapshot(0)
+		require.NoError(t, err)
+		hash, err := gv.Hash()
+		require.NoError(t, err)
+		return hash
+	}
+	hash := snapshothash(store)
+	require.Equal(t, hash, snapshothash(other))
+
+	compacted, err := store.CompactKeepLast(1)
+	require.NoError(t, err)
+	require.Equal(t, hash, snapshothash(compacted))
+
+	// proofs of one store verify against hash of the other
+	gv, err = other.LoadSnapshot(0)
+	require.NoError(t, err)
+	proof, err := gv.GenerateProof("tree1", []byte("a"))
+	require.NoError(t, err)
+	require.True(t, proof.VerifyMembership(hash, "tree1", []byte("a")))
+	require.Equal(t, []byte("changed"), proof.Value())
+
+	empty, err := NewMemStore()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, snapshothash(empty))
+}
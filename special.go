@@ -9,13 +9,14 @@ import "golang.org/x/xerrors"
 
 // this file contains some functions ( to extend read-only api). these apis are used in the dero blockchain.
 
+// hash of key using default hash function
 func Sum(key []byte) [HASHSIZE]byte {
-	return sum(key)
+	return DefaultHash.sum(key)
 }
 
 // we have a key and need to get both the key,value
 func (t *Tree) GetKeyValueFromKey(key []byte) (int, []byte, []byte, error) {
-	return t.root.GetKeyValue(t.store, sum(key), 256, 0)
+	return t.root.GetKeyValue(t.store, t.store.hash.sum(key), 256, 0)
 }
 
 // we only have a keyhash and need to get both the key,value
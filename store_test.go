@@ -137,9 +137,9 @@ func TestPersistantStore_fail(t *testing.T) {
 		require.NoError(t, err)
 		defer os.RemoveAll(dir) // clean up
 
-		s := Store{storage_layer: disk, base_directory: dir}
+		b := new_disk_backend(dir)
 
-		tmpfn := s.uint_to_filename(0) // basedir/0/0/0
+		tmpfn := b.uint_to_filename(0) // basedir/0/0/0
 		require.NoError(t, os.MkdirAll(tmpfn, 0700))
 
 		_, err = NewDiskStore(dir)
@@ -152,12 +152,12 @@ func TestPersistantStore_fail(t *testing.T) {
 		require.NoError(t, err)
 		defer os.RemoveAll(dir) // clean up
 
-		s := Store{storage_layer: disk, base_directory: dir}
+		b := new_disk_backend(dir)
 
-		tmpfn := filepath.Dir(filepath.Dir(s.uint_to_filename(0))) // basedir/0/0/0
+		tmpfn := filepath.Dir(filepath.Dir(b.uint_to_filename(0))) // basedir/0/0/0
 		require.NoError(t, os.MkdirAll(tmpfn, 0700))
 
-		tmpfn = filepath.Dir(filepath.Dir(s.uint_to_filename(0))) // basedir/0/0/0
+		tmpfn = filepath.Dir(filepath.Dir(b.uint_to_filename(0))) // basedir/0/0/0
 
 		require.NoError(t, ioutil.WriteFile(filepath.Join(tmpfn, "0"), []byte("dummy"), 0666))
 
@@ -190,14 +190,13 @@ func TestPersistantStore_fail(t *testing.T) {
 	// test if new writes will create new memory segments
 	{
 		store, err := NewMemStore()
-		store.files[0].size = MAX_FILE_SIZE
+		store.backend.(*memoryBackend).chunksize = 512
 		findex, fpos, err := store.write(make([]byte, 512, 512))
 		if findex != 1 || fpos != 0 || err != nil {
 			t.Fatalf("write failed")
 		}
 
-		store.storage_layer = unknown_layer
-		store.files[1].size = MAX_FILE_SIZE
+		store.Close()
 		_, _, err = store.write(make([]byte, 512, 512))
 		require.Error(t, err)
 	}
@@ -209,7 +208,7 @@ func TestPersistantStore_fail(t *testing.T) {
 		store, err := NewDiskStore(dir)
 		require.NoError(t, err)
 
-		store.files[0].size = MAX_FILE_SIZE
+		store.disk.files[0].size = MAX_FILE_SIZE
 		findex, fpos, err := store.write(make([]byte, 512, 512))
 		if findex != 1 || fpos != 0 || err != nil {
 			t.Fatalf("write failed")
@@ -226,11 +225,10 @@ func TestPersistantStore_fail(t *testing.T) {
 
 		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0", "0", "1"), []byte("dummy"), 0666))
 
-		store.findex = 255
+		store.disk.findex = 255
 
-		//t.Logf("filename %s", store.uint_to_filename(256))
-		store.files[255] = &file{memoryfile: make([]byte, 512, 512)}
-		store.files[255].size = MAX_FILE_SIZE
+		//t.Logf("filename %s", store.disk.uint_to_filename(256))
+		store.disk.files[255] = &datafile{size: MAX_FILE_SIZE}
 		_, _, err = store.write(make([]byte, 512, 512))
 		require.Error(t, err)
 	}
@@ -245,11 +243,10 @@ func TestPersistantStore_fail(t *testing.T) {
 
 		require.NoError(t, os.MkdirAll(filepath.Join(dir, "0", "0", "1", "256.dfs"), 0700))
 
-		store.findex = 255
+		store.disk.findex = 255
 
-		//t.Logf("filename %s", store.uint_to_filename(256))
-		store.files[255] = &file{memoryfile: make([]byte, 512, 512)}
-		store.files[255].size = MAX_FILE_SIZE
+		//t.Logf("filename %s", store.disk.uint_to_filename(256))
+		store.disk.files[255] = &datafile{size: MAX_FILE_SIZE}
 		_, _, err = store.write(make([]byte, 512, 512))
 		require.Error(t, err)
 	}
@@ -267,7 +264,7 @@ func TestPersistantStore_fail(t *testing.T) {
 
 		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0"), []byte("dummy"), 0666))
 
-		require.Error(t, store.create_first_file())
+		require.Error(t, store.disk.create_first_file())
 
 	}
 
@@ -284,7 +281,7 @@ func TestPersistantStore_fail(t *testing.T) {
 
 		require.NoError(t, os.MkdirAll(filepath.Join(dir, "0", "0", "0", "0.dfs"), 0700))
 
-		require.Error(t, store.create_first_file())
+		require.Error(t, store.disk.create_first_file())
 
 	}
 
@@ -299,9 +296,9 @@ func TestPersistantStore_fail(t *testing.T) {
 		store, err := NewDiskStore(dir)
 		require.NoError(t, err)
 
-		store.versionrootfile.diskfile.Close() // close version file handle to trigger error
+		store.disk.versionrootfile.Close() // close version file handle to trigger error
 
-		require.Error(t, store.writeVersionData(0, 0, 0))
+		require.Error(t, store.writeVersionData(1, 0, 0))
 
 	}
 
@@ -311,19 +308,14 @@ func TestPersistantStore_Empty(t *testing.T) {
 	var emptystore Store
 	require.Panics(t, func() { emptystore.Close() }) // empty store cannot close
 
-	require.Panics(t, func() { emptystore.uint_to_filename(0) }) // empty store cannot  translate filenames
-	emptystore.storage_layer = memory
-	require.Panics(t, func() { emptystore.uint_to_filename(0) }) // memory store cannot  translate filenames
+	_, err := emptystore.init()
+	require.Error(t, err) // store without backend cannot be opened
 
-	emptystore.storage_layer = unknown_layer
-	require.Error(t, emptystore.loadfiles()) // files cannot be loaded from unknown layer
-
-	emptystore.storage_layer = unknown_layer
-	require.Error(t, emptystore.writeVersionData(0, 0, 0)) // unknown cannot write version data
-	_, err := emptystore.LoadSnapshot(0)                   // unknown storage layer cannot give verions
+	require.Error(t, emptystore.writeVersionData(1, 0, 0)) // store without backend cannot write version data
+	_, err = emptystore.LoadSnapshot(0)                    // store without backend cannot give verions
 	require.Error(t, err)
 
-	_, _, err = emptystore.ReadVersionData(99) // unknown cannot read version data
+	_, _, err = emptystore.ReadVersionData(99) // store without backend cannot read version data
 	require.Error(t, err)
 
 	{
@@ -342,9 +334,8 @@ func TestPersistantStore_Empty(t *testing.T) {
 		require.Error(t, err)
 	}
 
-	emptystore.storage_layer = unknown_layer
 	_, err = emptystore.read(0, 0, nil)
-	require.Error(t, err) // unknown cannot read data
+	require.Error(t, err) // store without backend cannot read data
 
 	store, err := NewMemStore()
 
@@ -355,9 +346,7 @@ func TestPersistantStore_Empty(t *testing.T) {
 	_, _, err = emptystore.write([]byte{})
 	require.Error(t, err) // empty cannot write data
 
-	emptystore.files = map[uint32]*file{}
-	emptystore.files[0] = &file{memoryfile: make([]byte, 512, 512)}
-	_, err = emptystore.read(0, 2, nil)
-	require.Error(t, err) // empty store cannot read data
+	_, _, err = store.ReadVersionData(0) // versions are 1 based
+	require.Error(t, err)
 
 }
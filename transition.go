@@ -0,0 +1,125 @@
+package graviton
+
+import "fmt"
+import "bytes"
+
+// TransitionProof lets a stateless verifier, who only knows the old root of a tree, apply a set of puts and deletes
+// and compute the new root. It is a multiproof of all keys touched by the operations, which additionally proves
+// whether every sibling of their paths is a leaf or an inner node, since deletes move lone leaves up the tree.
+type TransitionProof struct {
+	witness MultiProof
+}
+
+// TransitionOp is a single put or delete, operations are applied in order
+type TransitionOp struct {
+	Key    []byte
+	Value  []byte // ignored for deletes
+	Delete bool
+}
+
+// ProveTransition generates a proof for applying ops to the tree, the tree itself is not modified
+func (t *Tree) ProveTransition(ops []TransitionOp) (*TransitionProof, error) {
+	keys := make([][]byte, 0, len(ops))
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+	}
+	mp, err := t.generate_multiproof(keys, true)
+	if err != nil {
+		return nil, err
+	}
+	return &TransitionProof{witness: *mp}, nil
+}
+
+// Verify the proof against old root built with default hash function, apply ops and return the new root
+func (tp *TransitionProof) Verify(oldroot [HASHSIZE]byte, ops []TransitionOp) (newroot [HASHSIZE]byte, err error) {
+	return tp.verify(DefaultHash, oldroot, ops)
+}
+
+// VerifyUsing replays ops against old root of a store created with the named hash function and returns the new
+// root
+func (tp *TransitionProof) VerifyUsing(hashname string, oldroot [HASHSIZE]byte, ops []TransitionOp) (newroot [HASHSIZE]byte, err error) {
+	hf, err := GetHash(hashname)
+	if err != nil {
+		return
+	}
+	return tp.verify(hf, oldroot, ops)
+}
+
+func (tp *TransitionProof) verify(hf *HashFunction, oldroot [HASHSIZE]byte, ops []TransitionOp) (newroot [HASHSIZE]byte, err error) {
+	root := tp.witness.root
+	if root == nil || root.kind != mp_inner {
+		return newroot, fmt.Errorf("empty transition proof")
+	}
+	if !bytes.Equal(root.roothash(hf), oldroot[:]) {
+		return newroot, fmt.Errorf("transition proof does not match root")
+	}
+
+	// paths of all keys must be complete and their siblings must not be bare hashes
+	for _, op := range ops {
+		keyhash := hf.sum(op.Key)
+		n := root
+		for bit := uint(0); n.kind == mp_inner; bit++ {
+			if n.left.kind == mp_hash || n.right.kind == mp_hash {
+				return newroot, fmt.Errorf("transition proof does not cover key %x", op.Key)
+			}
+			if isBitSet(keyhash[:], bit) {
+				n = n.right
+			} else {
+				n = n.left
+			}
+		}
+	}
+
+	// operations are applied to a tree built from the witness, so as updates follow exactly the same rules as
+	// regular trees, subtrees known only by hash are never reached
+	store := &Store{hash: hf, format: STORE_FORMAT_VERSION}
+	tree := &Tree{store: store, root: witness_node(store, root, 0).(*inner)}
+	for _, op := range ops {
+		if op.Delete {
+			err = tree.Delete(op.Key)
+		} else {
+			err = tree.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return tree.Hash()
+}
+
+// convert witness to regular nodes, bit is the bit used by an inner node at this depth
+func witness_node(store *Store, n *mpnode, bit int) node {
+	switch n.kind {
+	case mp_hash:
+		in := &inner{bit: uint8(bit)}
+		in.hash = append(in.hash_backer[:0], n.hash...)
+		return in
+	case mp_inner:
+		in := newInner(uint8(bit))
+		in.left = witness_node(store, n.left, bit+1)
+		in.right = witness_node(store, n.right, bit+1)
+		return in
+	case mp_value:
+		var keyhash [HASHSIZE]byte
+		copy(keyhash[:], n.keyhash)
+		return newLeaf(store, keyhash, nil, n.value)
+	case mp_leaf:
+		l := &leaf{}
+		copy(l.keyhash[:], n.keyhash)
+		copy(l.hash[:], store.hash.leafHash(n.keyhash, n.hash))
+		l.hash_check = l.hash
+		return l
+	default: // mp_empty
+		return nil
+	}
+}
+
+// Serialize the transition proof to a byte array, format is same as multiproof
+func (tp *TransitionProof) Marshal() []byte {
+	return tp.witness.Marshal()
+}
+
+// Unmarshal deserializes a transition proof for verification
+func (tp *TransitionProof) Unmarshal(buf []byte) error {
+	return tp.witness.Unmarshal(buf)
+}
@@ -0,0 +1,125 @@
+package graviton
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransitionProof(t *testing.T) {
+	store, err := NewMemStore()
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, tree.Commit())
+	oldroot := tree.hashSkipError()
+
+	rand.Seed(13)
+	for round := 0; round < 50; round++ {
+		var ops []TransitionOp
+		for i := 0; i < 1+rand.Intn(20); i++ {
+			key := []byte(fmt.Sprintf("key%d", rand.Intn(300))) // some keys do not exist
+			if rand.Intn(2) == 0 {
+				ops = append(ops, TransitionOp{Key: key, Delete: true})
+			} else {
+				ops = append(ops, TransitionOp{Key: key, Value: []byte(fmt.Sprintf("new%d", round))})
+			}
+		}
+
+		gv, err = store.LoadSnapshot(0)
+		require.NoError(t, err)
+		tree, err = gv.GetTree("root")
+		require.NoError(t, err)
+
+		tp, err := tree.ProveTransition(ops)
+		require.NoError(t, err)
+
+		var decoded TransitionProof
+		require.NoError(t, decoded.Unmarshal(tp.Marshal()))
+
+		// apply ops to the real tree
+		for _, op := range ops {
+			if op.Delete {
+				require.NoError(t, tree.Delete(op.Key))
+			} else {
+				require.NoError(t, tree.Put(op.Key, op.Value))
+			}
+		}
+
+		newroot, err := decoded.Verify(oldroot, ops)
+		require.NoError(t, err)
+		require.Equal(t, tree.hashSkipError(), newroot, "round %d", round)
+	}
+
+	gv, err = store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err = gv.GetTree("root")
+	require.NoError(t, err)
+	ops := []TransitionOp{{Key: []byte("key1"), Delete: true}, {Key: []byte("key500"), Value: []byte("value")}}
+	tp, err := tree.ProveTransition(ops)
+	require.NoError(t, err)
+
+	// operations on keys outside the proof
+	_, err = tp.Verify(oldroot, append(ops, TransitionOp{Key: []byte("key2"), Delete: true}))
+	require.Error(t, err)
+
+	// wrong root
+	wrong := oldroot
+	wrong[0] ^= 1
+	_, err = tp.Verify(wrong, ops)
+	require.Error(t, err)
+
+	// a multiproof does not prove types of siblings
+	mp, err := tree.GenerateMultiProof([][]byte{[]byte("key1"), []byte("key500")})
+	require.NoError(t, err)
+	var fromMultiProof TransitionProof
+	require.NoError(t, fromMultiProof.Unmarshal(mp.Marshal()))
+	_, err = fromMultiProof.Verify(oldroot, ops)
+	require.Error(t, err)
+}
+
+// deleting keys until the tree is empty collapses all inner nodes
+func TestTransitionProofEmpty(t *testing.T) {
+	store, err := NewMemStore(StoreOptions{Hash: HASH_SHA256})
+	require.NoError(t, err)
+	gv, err := store.LoadSnapshot(0)
+	require.NoError(t, err)
+	tree, err := gv.GetTree("root")
+	require.NoError(t, err)
+
+	var ops []TransitionOp
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		require.NoError(t, tree.Put(key, key))
+		ops = append(ops, TransitionOp{Key: key, Delete: true})
+	}
+	oldroot := tree.hashSkipError()
+
+	tp, err := tree.ProveTransition(ops)
+	require.NoError(t, err)
+	newroot, err := tp.VerifyUsing(HASH_SHA256, oldroot, ops)
+	require.NoError(t, err)
+
+	for _, op := range ops {
+		require.NoError(t, tree.Delete(op.Key))
+	}
+	require.Equal(t, tree.hashSkipError(), newroot)
+
+	// and back
+	for i := range ops {
+		ops[i].Delete, ops[i].Value = false, ops[i].Key
+	}
+	tp, err = tree.ProveTransition(ops)
+	require.NoError(t, err)
+	newroot, err = tp.VerifyUsing(HASH_SHA256, tree.hashSkipError(), ops)
+	require.NoError(t, err)
+	require.Equal(t, oldroot, newroot)
+}
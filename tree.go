@@ -41,21 +41,21 @@ func (t *Tree) GetParentVersion() uint64 {
 // ToDO: it should ignore duplicate key value, if first using a get and then a put
 //
 func (t *Tree) Put(key, value []byte) error {
-	return t.putRaw(sum(key), key, value)
+	return t.putRaw(t.store.hash.sum(key), key, value)
 }
 func (t *Tree) putRaw(keyhash [HASHSIZE]byte, key, value []byte) error {
 	if len(value) > MAX_VALUE_SIZE {
 		return xerrors.Errorf("value is longer then max allowed value size, %d > %d", len(value), MAX_VALUE_SIZE)
 	}
 
-	leaf := newLeaf(keyhash, key, value)
+	leaf := newLeaf(t.store, keyhash, key, value)
 	return t.root.Insert(t.store, leaf)
 }
 
 // Get a specifically value associated with a key
 // TODO, we need to expose this in other forms so as memory allocations and better error detection could be done
 func (t *Tree) Get(key []byte) ([]byte, error) {
-	return t.getRaw(sum(key))
+	return t.getRaw(t.store.hash.sum(key))
 }
 
 // Get a specific value associated with a specific key hash
@@ -77,7 +77,7 @@ func (t *Tree) Hash() (h [HASHSIZE]byte, err error) {
 
 // delete a specific key from the tree
 func (t *Tree) Delete(key []byte) error {
-	_, _, err := t.root.Delete(t.store, sum(key))
+	_, _, err := t.root.Delete(t.store, t.store.hash.sum(key))
 	return err
 }
 
@@ -92,10 +92,20 @@ func (t *Tree) IsDirty() bool {
 // queried from a number of sources and then it is verified
 func (t *Tree) GenerateProof(key []byte) (*Proof, error) {
 	var p Proof
-	err := t.generateProofRaw(sum(key), &p)
+	err := t.generateProofRaw(t.store.hash.sum(key), &p)
 	return &p, err
 }
 
+// Generate proof of any key, membership proofs carry only hash of the value instead of the value. The value can be
+// fetched from any untrusted source and checked using VerifyValue
+func (t *Tree) GenerateProofWithValueHash(key []byte) (*Proof, error) {
+	p, err := t.GenerateProof(key)
+	if err == nil {
+		p.hashValue(t.store.hash)
+	}
+	return p, err
+}
+
 func (t *Tree) generateProofRaw(key [HASHSIZE]byte, proof *Proof) error {
 	return t.root.Prove(t.store, key, proof)
 }
@@ -108,8 +118,27 @@ func Commit(trees ...*Tree) (committed_version uint64, err error) {
 		return 0, nil
 	}
 
-	trees[0].store.commitsync.Lock()
-	defer trees[0].store.commitsync.Unlock()
+	store := trees[0].store
+	store.commitsync.Lock()
+	if store.header_upgrade {
+		err = store.upgrade_header()
+	}
+	if err == nil {
+		committed_version, err = commit_locked(trees...)
+	}
+	if err == nil && store.disk != nil && store.options.Sync == SyncCommit {
+		err = store.flush()
+	}
+	store.commitsync.Unlock()
+
+	if err == nil && store.disk != nil && store.options.Sync == SyncGroupCommit { // wait outside the lock so as other commits can join the group
+		err = store.groupsync(committed_version)
+	}
+	return
+}
+
+// commit while holding the commit lock
+func commit_locked(trees ...*Tree) (committed_version uint64, err error) {
 
 	// sanity checkthat all trees were derived from the same snapshot
 	first_tree_snapshot_version := trees[0].snapshot_version
@@ -185,17 +214,17 @@ func (gv *Snapshot) commit(tree *Tree) (err error) {
 		done += copy(key[done:], []byte(tree.treename))
 		done += binary.PutUvarint(key[done:], tree.root.version_current)
 
-		if err = gv.vroot.Insert(tree.store, newLeaf(sum(key[:done]), key[:done], valuebuf[:])); err == nil { // always ensure tree is accessible by its bucket & version number
+		if err = gv.vroot.Insert(tree.store, newLeaf(tree.store, tree.store.hash.sum(key[:done]), key[:done], valuebuf[:])); err == nil { // always ensure tree is accessible by its bucket & version number
 
 			done = 1
 			done += copy(key[done:], []byte(tree.treename))
 			done += copy(key[done:], roothash[:])
-			if err = gv.vroot.Insert(tree.store, newLeaf(sum(key[:done]), key[:done], valuebuf[:])); err == nil {
+			if err = gv.vroot.Insert(tree.store, newLeaf(tree.store, tree.store.hash.sum(key[:done]), key[:done], valuebuf[:])); err == nil {
 
-				if err = gv.vroot.Insert(tree.store, newLeaf(sum(roothash[:]), roothash[:], valuebuf[:])); err == nil {
+				if err = gv.vroot.Insert(tree.store, newLeaf(tree.store, tree.store.hash.sum(roothash[:]), roothash[:], valuebuf[:])); err == nil {
 
 					for i := 0; i < len(tree.Tags) && err == nil; i++ {
-						err = gv.vroot.Insert(tree.store, newLeaf(sum([]byte(tree.Tags[i])), []byte(tree.Tags[i]), valuebuf[:]))
+						err = gv.vroot.Insert(tree.store, newLeaf(tree.store, tree.store.hash.sum([]byte(tree.Tags[i])), []byte(tree.Tags[i]), valuebuf[:]))
 					}
 				}
 			}
@@ -242,18 +271,7 @@ func (t *Tree) Discard() error {
 func (t *Tree) commit_leaf(level int, l *leaf) (findex uint32, fpos uint32, err error) {
 
 	t.tmp_buffer.Reset()
-	var tbuf [10]byte
-
-	size := binary.PutUvarint(tbuf[:], uint64(len(l.key)))
-	t.tmp_buffer.Write(tbuf[:size])
-	if len(l.key) > 0 {
-		t.tmp_buffer.Write(l.key[:])
-	}
-	size = binary.PutUvarint(tbuf[:], uint64(len(l.value)))
-	t.tmp_buffer.Write(tbuf[:size])
-	if len(l.value) > 0 {
-		t.tmp_buffer.Write(l.value[:])
-	}
+	l.MarshalTo(&t.tmp_buffer)
 
 	// here we must write it to store
 	t.size += len(t.tmp_buffer.Bytes())
@@ -230,7 +230,7 @@ func TestDiscard(t *testing.T) {
 	tree.Put([]byte{46}, []byte{89}) // tree is dirty now
 	require.Equal(t, true, tree.IsDirty())
 
-	store.versionrootfile.diskfile.Truncate(0)
+	store.disk.versionrootfile.Truncate(0)
 	require.Error(t, tree.Discard())
 
 }
@@ -0,0 +1,162 @@
+package graviton
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/deroproject/graviton/verifier"
+	"github.com/stretchr/testify/require"
+)
+
+var update_vectors = flag.Bool("update-vectors", false, "regenerate golden test vectors of verifier package")
+
+const vectors_file = "verifier/testdata/vectors.json"
+
+// proofVector is a golden test vector, byte fields are hex encoded
+type proofVector struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Root   string `json:"root"`
+	Key    string `json:"key"`
+	Proof  string `json:"proof"`
+	Kind   string `json:"kind"`            // member, memberhash, collision, deadend or invalid
+	Value  string `json:"value,omitempty"` // value of member and memberhash proofs
+	Reason string `json:"reason,omitempty"`
+}
+
+var proof_kinds = map[byte]string{member: "member", memberhash: "memberhash", collision: "collision", deadend: "deadend"}
+
+func generateVectors(t *testing.T) (vectors []proofVector) {
+	add := func(name string, tree *Tree, key []byte, valuehash bool) *Proof {
+		proof, err := tree.GenerateProof(key)
+		require.NoError(t, err)
+		if valuehash {
+			proof.hashValue(tree.store.hash)
+		}
+		root := tree.hashSkipError()
+		v := proofVector{Name: name, Hash: tree.store.hash.Name, Root: hex.EncodeToString(root[:]), Key: hex.EncodeToString(key),
+			Proof: hex.EncodeToString(proof.Marshal()), Kind: proof_kinds[proof.ptype()]}
+		if proof.ptype() == member || proof.ptype() == memberhash {
+			value, err := tree.Get(key)
+			require.NoError(t, err)
+			v.Value = hex.EncodeToString(value)
+		}
+		vectors = append(vectors, v)
+		return proof
+	}
+	invalid := func(name string, buf []byte, reason string) {
+		vectors = append(vectors, proofVector{Name: name, Hash: HASH_BLAKE2S, Proof: hex.EncodeToString(buf), Kind: "invalid", Reason: reason})
+	}
+	newtree := func(hash string, keycount int) *Tree {
+		store, err := NewMemStore(StoreOptions{Hash: hash})
+		require.NoError(t, err)
+		gv, err := store.LoadSnapshot(0)
+		require.NoError(t, err)
+		tree, err := gv.GetTree("root")
+		require.NoError(t, err)
+		for i := 0; i < keycount; i++ {
+			require.NoError(t, tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))))
+		}
+		return tree
+	}
+	// find missing keys whose proofs are of specific kind
+	missing := func(tree *Tree, ptype byte) []byte {
+		for i := 0; ; i++ {
+			key := []byte(fmt.Sprintf("missing%d", i))
+			proof, err := tree.GenerateProof(key)
+			require.NoError(t, err)
+			if proof.ptype() == ptype {
+				return key
+			}
+		}
+	}
+
+	tree := newtree(HASH_BLAKE2S, 0)
+	add("empty tree", tree, []byte("key0"), false)
+
+	tree = newtree(HASH_BLAKE2S, 1)
+	add("single key", tree, []byte("key0"), false)
+	add("single key, missing key", tree, missing(tree, deadend), false)
+
+	tree = newtree(HASH_BLAKE2S, 100)
+	for i := 0; i < 3; i++ {
+		add(fmt.Sprintf("member %d", i), tree, []byte(fmt.Sprintf("key%d", i)), false)
+	}
+	proof := add("member with value hash", tree, []byte("key3"), true)
+	add("collision", tree, missing(tree, collision), false)
+	add("deadend", tree, missing(tree, deadend), false)
+	require.NoError(t, tree.Put([]byte("empty value"), nil))
+	add("member with empty value", tree, []byte("empty value"), false)
+
+	tree = newtree(HASH_SHA256, 100)
+	add("sha256 member", tree, []byte("key7"), false)
+	add("sha256 collision", tree, missing(tree, collision), false)
+	add("sha256 deadend", tree, missing(tree, deadend), false)
+
+	buf := proof.Marshal()
+	invalid("truncated", buf[:len(buf)-1], "truncated")
+	invalid("trailing byte", append(append([]byte{}, buf...), 0), "malformed")
+	invalid("unknown version", append([]byte{2}, buf[1:]...), "version")
+	invalid("unknown type", append([]byte{1, 9}, buf[2:]...), "malformed")
+	invalid("non minimal trace length", append([]byte{1, deadend, 0x81, 0x00}, make([]byte, HASHSIZE)...), "malformed")
+	invalid("trace too long", append([]byte{1, deadend, 0x81, 0x02}, make([]byte, HASHSIZE)...), "malformed")
+	return
+}
+
+// golden vectors must match proofs generated by this release and must be verified by both verifiers
+func TestVerifierVectors(t *testing.T) {
+	vectors := generateVectors(t)
+	for _, v := range vectors {
+		root, key, value := decodeVector(t, v)
+		buf, err := hex.DecodeString(v.Proof)
+		require.NoError(t, err)
+
+		var proof Proof
+		var vproof verifier.Proof
+		if v.Kind == "invalid" {
+			require.Error(t, proof.Unmarshal(buf), v.Name)
+			require.Error(t, vproof.Unmarshal(buf), v.Name)
+			continue
+		}
+		require.NoError(t, proof.Unmarshal(buf), v.Name)
+		require.NoError(t, vproof.Unmarshal(buf), v.Name)
+
+		member, valuehash := v.Kind == "member", v.Kind == "memberhash"
+		require.Equal(t, member, proof.VerifyMembershipUsing(v.Hash, root, key), v.Name)
+		require.Equal(t, member, vproof.VerifyMembershipUsing(v.Hash, root, key), v.Name)
+		require.Equal(t, member || valuehash, vproof.VerifyMembershipWithValueHashUsing(v.Hash, root, key), v.Name)
+		require.Equal(t, !member && !valuehash, proof.VerifyNonMembershipUsing(v.Hash, root, key), v.Name)
+		require.Equal(t, !member && !valuehash, vproof.VerifyNonMembershipUsing(v.Hash, root, key), v.Name)
+		if member || valuehash {
+			require.True(t, vproof.VerifyValueUsing(v.Hash, value), v.Name)
+		}
+		if member {
+			require.Equal(t, value, vproof.Value(), v.Name)
+		}
+	}
+
+	encoded, err := json.MarshalIndent(vectors, "", "  ")
+	require.NoError(t, err)
+	encoded = append(encoded, '\n')
+	if *update_vectors {
+		require.NoError(t, ioutil.WriteFile(vectors_file, encoded, 0644))
+	}
+	golden, err := ioutil.ReadFile(vectors_file)
+	require.NoError(t, err)
+	require.Equal(t, string(golden), string(encoded), "golden vectors changed, run go test -run TestVerifierVectors -update-vectors")
+}
+
+func decodeVector(t *testing.T, v proofVector) (root [HASHSIZE]byte, key, value []byte) {
+	rootbuf, err := hex.DecodeString(v.Root)
+	require.NoError(t, err)
+	copy(root[:], rootbuf)
+	key, err = hex.DecodeString(v.Key)
+	require.NoError(t, err)
+	value, err = hex.DecodeString(v.Value)
+	require.NoError(t, err)
+	return
+}
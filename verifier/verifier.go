@@ -0,0 +1,483 @@
+// Copyright dero developers
+
+/*
+Package verifier verifies graviton proofs without depending on the rest of graviton ( stores, disk I/O etc ).
+It only needs the standard library and blake2s, so it can be used by constrained clients such as TinyGo/WASM builds.
+
+Proofs are generated by graviton using tree.GenerateProof or tree.GenerateProofWithValueHash and serialized using
+proof.Marshal. testdata/vectors.json holds golden test vectors generated from real trees, which may also be used to
+test implementations in other languages.
+*/
+package verifier
+
+import "fmt"
+import "hash"
+import "bytes"
+import "sync"
+import "errors"
+import "crypto/sha256"
+import "encoding/binary"
+
+import "golang.org/x/crypto/blake2s"
+
+const HASHSIZE = 32
+const HASHSIZE_BITS = HASHSIZE * 8
+const MAX_VALUE_SIZE = 100 * 1024 * 1024 // values are limited to this size
+
+// names of built in hash functions, others can be added using RegisterHash
+const (
+	HASH_BLAKE2S = "blake2s" // default
+	HASH_SHA256  = "sha256"
+)
+
+var (
+	ErrProofVersion   = errors.New("unsupported proof version")
+	ErrTruncatedProof = errors.New("proof is truncated")
+	ErrMalformedProof = errors.New("proof is malformed")
+)
+
+// node types used while hashing
+const (
+	innerNODE byte = 1
+	leafNODE  byte = 2
+)
+
+// proof types, returned by Proof.Type
+const (
+	Member byte = iota + 1
+	Collision
+	Deadend
+	MemberHash // member, but only hash of value is carried
+)
+
+// registered hash function, hash of empty subtrees is computed once at registration
+type hash_function struct {
+	new       func() hash.Hash
+	zerosHash []byte
+}
+
+func new_hash_function(new func() hash.Hash) *hash_function {
+	return &hash_function{new: new, zerosHash: leafHash(new, make([]byte, HASHSIZE), nil)}
+}
+
+var hash_functions = map[string]*hash_function{
+	HASH_BLAKE2S: new_hash_function(func() hash.Hash { h, _ := blake2s.New256(nil); return h }),
+	HASH_SHA256:  new_hash_function(sha256.New),
+}
+var hash_functions_lock sync.RWMutex
+
+// RegisterHash makes a hash function available for verification, the hash must produce HASHSIZE bytes
+// a name can only be registered once, hash functions may be registered while proofs are being verified
+func RegisterHash(name string, new func() hash.Hash) error {
+	if name == "" || new == nil {
+		return fmt.Errorf("hash function name and implementation are required")
+	}
+	if size := new().Size(); size != HASHSIZE {
+		return fmt.Errorf("hash function %s produces %d bytes, %d bytes are required", name, size, HASHSIZE)
+	}
+	hf := new_hash_function(new)
+
+	hash_functions_lock.Lock()
+	defer hash_functions_lock.Unlock()
+	if _, ok := hash_functions[name]; ok {
+		return fmt.Errorf("hash function %s is already registered", name)
+	}
+	hash_functions[name] = hf
+	return nil
+}
+
+// nil if the hash function is not registered
+func get_hash(name string) *hash_function {
+	if name == "" {
+		name = HASH_BLAKE2S
+	}
+	hash_functions_lock.RLock()
+	defer hash_functions_lock.RUnlock()
+	return hash_functions[name]
+}
+
+// hashes of empty subtrees of all registered hash functions
+func empty_hashes() (empty [][]byte) {
+	hash_functions_lock.RLock()
+	defer hash_functions_lock.RUnlock()
+	for _, hf := range hash_functions {
+		empty = append(empty, hf.zerosHash)
+	}
+	return
+}
+
+func sum(new func() hash.Hash, parts ...[]byte) []byte {
+	h := new()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(make([]byte, 0, HASHSIZE))
+}
+
+// leaf hash is hash of key hash and value hash
+func leafHash(new func() hash.Hash, hkey, hvalue []byte) []byte {
+	return sum(new, []byte{leafNODE}, hkey, hvalue)
+}
+
+func isBitSet(buf []byte, index uint) bool {
+	pos, bit := index/8, index%8
+	return (buf[pos] & (1 << (8 - (bit + 1)))) > 0
+}
+
+func setBit(buf []byte, index uint) {
+	pos, bit := index/8, index%8
+	buf[pos] |= (1 << (8 - (bit + 1)))
+}
+
+// Proof proves existence or non-existence of a key against a tree root hash
+type Proof struct {
+	ptype     byte
+	trace     [][]byte // sibling hashes from root, nil for empty siblings
+	value     []byte   // only for member proofs
+	valuehash []byte   // only for memberhash proofs
+	ckey      []byte   // collision key hash
+	cval      []byte   // collision value hash
+}
+
+// Reset prepares the proof for reuse, proofs are built by graviton while walking a tree from the root
+func (p *Proof) Reset() {
+	for i := range p.trace {
+		p.trace[i] = nil
+	}
+	*p = Proof{trace: p.trace[:0]}
+}
+
+// AddTrace appends the hash of sibling at next depth, nil for empty siblings
+func (p *Proof) AddTrace(sibling []byte) {
+	p.trace = append(p.trace, sibling)
+}
+
+// SetMember ends the proof at the leaf of key
+func (p *Proof) SetMember(value []byte) {
+	p.ptype = Member
+	p.value = value
+}
+
+// SetCollision ends the proof at a leaf of another key
+func (p *Proof) SetCollision(keyhash, valuehash []byte) {
+	p.ptype = Collision
+	p.ckey = keyhash
+	p.cval = valuehash
+}
+
+// SetDeadend ends the proof at an empty subtree
+func (p *Proof) SetDeadend() {
+	p.ptype = Deadend
+}
+
+// HashValue replaces value of a member proof with its hash, so as large values can be fetched separately and
+// checked using VerifyValue
+func (p *Proof) HashValue(new func() hash.Hash) {
+	if p.ptype == Member {
+		p.ptype = MemberHash
+		p.valuehash = sum(new, p.value)
+		p.value = nil
+	}
+}
+
+// Type returns the type of proof, 0 for empty proofs
+func (p *Proof) Type() byte {
+	return p.ptype
+}
+
+// Trace returns sibling hashes from root, nil for empty siblings
+func (p *Proof) Trace() [][]byte {
+	return p.trace
+}
+
+func (p *Proof) rootForLeaf(new func() hash.Hash, zerosHash, keyhash, leaf []byte) []byte {
+	h := new()
+	rst := append(make([]byte, 0, HASHSIZE), leaf...)
+	for i := len(p.trace) - 1; i >= 0; i-- {
+		sibling := p.trace[i]
+		if sibling == nil { // empty sibling, not present in serialized proof
+			sibling = zerosHash
+		}
+		h.Write([]byte{innerNODE})
+		if isBitSet(keyhash, uint(i)) {
+			h.Write(sibling)
+			h.Write(rst)
+		} else {
+			h.Write(rst)
+			h.Write(sibling)
+		}
+		rst = h.Sum(rst[:0])
+		h.Reset()
+	}
+	return rst
+}
+
+// CheckKeyHash verifies the proof for a key hash against root of a tree built with hash function new, zerosHash is
+// the hash of empty subtrees of the hash function. It is used by graviton to verify proofs with its own hash
+// functions, member reports whether the proof is a membership proof
+func (p *Proof) CheckKeyHash(new func() hash.Hash, zerosHash []byte, root [HASHSIZE]byte, keyhash []byte) (valid, member bool) {
+	if len(keyhash) != HASHSIZE || len(zerosHash) != HASHSIZE {
+		return false, false
+	}
+	var leaf []byte
+	switch p.ptype {
+	case Member, MemberHash:
+		leaf, member = leafHash(new, keyhash, p.valueHash(new)), true
+	case Collision:
+		leaf = leafHash(new, p.ckey, p.cval)
+	case Deadend:
+		leaf = zerosHash
+	default:
+		return false, false
+	}
+	for _, sibling := range p.trace { // empty siblings have a single encoding
+		if bytes.Equal(sibling, zerosHash) {
+			return false, false
+		}
+	}
+	return bytes.Equal(root[:], p.rootForLeaf(new, zerosHash, keyhash, leaf)), member
+}
+
+// CheckValue checks a value against a membership proof of a tree built with hash function new
+func (p *Proof) CheckValue(new func() hash.Hash, value []byte) bool {
+	valuehash := p.valueHash(new)
+	return valuehash != nil && bytes.Equal(sum(new, value), valuehash)
+}
+
+// verify membership of a key in a tree built with default hash function
+func (p *Proof) VerifyMembership(root [HASHSIZE]byte, key []byte) bool {
+	return p.VerifyMembershipUsing(HASH_BLAKE2S, root, key)
+}
+
+// VerifyMembershipUsing verifies that key and the value carried by the proof are in the tree with root, hashname
+// selects a built in or registered hash function. Proofs carrying only the value hash are rejected
+func (p *Proof) VerifyMembershipUsing(hashname string, root [HASHSIZE]byte, key []byte) bool {
+	if p.ptype != Member { // proof must carry the value
+		return false
+	}
+	return p.VerifyMembershipWithValueHashUsing(hashname, root, key)
+}
+
+// verify membership of a key in a tree built with default hash function, proof may carry only the hash of value
+// the value itself is not authenticated till it is checked using VerifyValue
+func (p *Proof) VerifyMembershipWithValueHash(root [HASHSIZE]byte, key []byte) bool {
+	return p.VerifyMembershipWithValueHashUsing(HASH_BLAKE2S, root, key)
+}
+
+// VerifyMembershipWithValueHashUsing verifies that key is in the tree with root, the proof may carry the value or
+// only its hash. Pair it with VerifyValueUsing, as the value is not authenticated by this step
+func (p *Proof) VerifyMembershipWithValueHashUsing(hashname string, root [HASHSIZE]byte, key []byte) bool {
+	hf := get_hash(hashname)
+	if hf == nil {
+		return false
+	}
+	valid, member := p.CheckKeyHash(hf.new, hf.zerosHash, root, sum(hf.new, key))
+	return valid && member
+}
+
+// verify non membership of a key in a tree built with default hash function
+func (p *Proof) VerifyNonMembership(root [HASHSIZE]byte, key []byte) bool {
+	return p.VerifyNonMembershipUsing(HASH_BLAKE2S, root, key)
+}
+
+// VerifyNonMembershipUsing verifies that key is not in the tree with root, built with the named hash function.
+// It fails for unregistered names
+func (p *Proof) VerifyNonMembershipUsing(hashname string, root [HASHSIZE]byte, key []byte) bool {
+	hf := get_hash(hashname)
+	if hf == nil {
+		return false
+	}
+	valid, member := p.CheckKeyHash(hf.new, hf.zerosHash, root, sum(hf.new, key))
+	return valid && !member
+}
+
+// hash of value of a membership proof
+func (p *Proof) valueHash(new func() hash.Hash) []byte {
+	switch p.ptype {
+	case Member:
+		return sum(new, p.value)
+	case MemberHash:
+		return p.valuehash
+	}
+	return nil
+}
+
+// VerifyValue checks a value fetched separately against a membership proof of a tree built with default hash
+// function, the proof itself must be verified using VerifyMembershipWithValueHash
+func (p *Proof) VerifyValue(value []byte) bool {
+	return p.VerifyValueUsing(HASH_BLAKE2S, value)
+}
+
+// VerifyValueUsing checks a value fetched separately against the value hash of a membership proof, hashing it
+// with the named hash function. It does not check the proof against a root
+func (p *Proof) VerifyValueUsing(hashname string, value []byte) bool {
+	hf := get_hash(hashname)
+	if hf == nil {
+		return false
+	}
+	return p.CheckValue(hf.new, value)
+}
+
+// if the proof is for existence for a key, it's associated value can be read here, proofs carrying only
+// the hash of value return empty value
+func (p *Proof) Value() []byte {
+	return append([]byte{}, p.value...)
+}
+
+// Marshal serializes the proof, see Unmarshal for the format
+func (p *Proof) Marshal() []byte {
+	var b bytes.Buffer
+	p.MarshalTo(&b)
+	return b.Bytes()
+}
+
+// MarshalTo serializes the proof to a bytes Buffer, buffer may already hold data
+func (p *Proof) MarshalTo(b *bytes.Buffer) {
+	var buf [binary.MaxVarintLen64]byte
+	var tracebits [HASHSIZE]byte // bit is set if sibling is not empty
+	for i := range p.trace {
+		if p.trace[i] != nil {
+			setBit(tracebits[:], uint(i))
+		}
+	}
+
+	b.WriteByte(1) // version
+	b.WriteByte(p.ptype)
+	b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(p.trace)))])
+	b.Write(tracebits[:])
+	for i := range p.trace {
+		if p.trace[i] != nil {
+			b.Write(p.trace[i])
+		}
+	}
+	switch p.ptype {
+	case Collision:
+		b.Write(p.ckey)
+		b.Write(p.cval)
+	case Member:
+		b.Write(buf[:binary.PutUvarint(buf[:], uint64(len(p.value)))])
+		b.Write(p.value)
+	case MemberHash: // value is dispatched separately
+		b.Write(p.valuehash)
+	}
+}
+
+// Unmarshal deserializes a proof serialized by graviton, every length is checked and only the canonical encoding of
+// a proof is accepted, errors wrap ErrProofVersion, ErrTruncatedProof or ErrMalformedProof. Empty siblings must not be
+// sent, siblings equal to the empty hash of a registered hash function are rejected here, others during verification
+//
+//	1 byte version
+//	1 byte type
+//	varint trace length
+//	32 byte(HASHSIZE) tracebits, bit 1 is set if sibling is not empty
+//	32 byte(HASHSIZE) * number of trace bits set
+//	if collision 32 byte(HASHSIZE) key hash, 32 byte(HASHSIZE) value hash
+//	if member varint length prefixed value
+//	if memberhash 32 byte(HASHSIZE) value hash
+func (p *Proof) Unmarshal(buf []byte) error {
+	*p = Proof{}
+	if len(buf) < 2 {
+		return fmt.Errorf("%w: %d bytes", ErrTruncatedProof, len(buf))
+	}
+	if buf[0] != 1 {
+		return fmt.Errorf("%w: %d", ErrProofVersion, buf[0])
+	}
+	p.ptype = buf[1]
+	if p.ptype < Member || p.ptype > MemberHash {
+		return fmt.Errorf("%w: unknown proof type %d", ErrMalformedProof, p.ptype)
+	}
+
+	tracelength, done, err := ReadUvarint(buf, 2)
+	if err != nil {
+		return err
+	}
+	if tracelength < 1 || tracelength > HASHSIZE_BITS {
+		return fmt.Errorf("%w: trace length %d", ErrMalformedProof, tracelength)
+	}
+	tracebits, done, err := read_hash(buf, done)
+	if err != nil {
+		return err
+	}
+	for i := uint(tracelength); i < HASHSIZE_BITS; i++ {
+		if isBitSet(tracebits, i) {
+			return fmt.Errorf("%w: trace bit %d set beyond trace length %d", ErrMalformedProof, i, tracelength)
+		}
+	}
+
+	empty := empty_hashes() // hash of empty subtree depends on hash function
+	p.trace = make([][]byte, tracelength)
+	for i := range p.trace {
+		if isBitSet(tracebits, uint(i)) {
+			if p.trace[i], done, err = read_hash(buf, done); err != nil {
+				return err
+			}
+			for _, zerosHash := range empty {
+				if bytes.Equal(p.trace[i], zerosHash) {
+					return fmt.Errorf("%w: trace %d is an empty sibling", ErrMalformedProof, i)
+				}
+			}
+		}
+	}
+
+	switch p.ptype {
+	case Collision:
+		if p.ckey, done, err = read_hash(buf, done); err == nil {
+			p.cval, done, err = read_hash(buf, done)
+		}
+	case Member:
+		p.value, done, err = ReadBytes(buf, done, MAX_VALUE_SIZE)
+	case MemberHash:
+		p.valuehash, done, err = read_hash(buf, done)
+	}
+	if err != nil {
+		return err
+	}
+	if done != len(buf) {
+		return fmt.Errorf("%w: %d extra bytes", ErrMalformedProof, len(buf)-done)
+	}
+	return nil
+}
+
+// read HASHSIZE bytes at pos, returns position after them
+func read_hash(buf []byte, pos int) ([]byte, int, error) {
+	if len(buf) < pos+HASHSIZE {
+		return nil, pos, fmt.Errorf("%w: hash", ErrTruncatedProof)
+	}
+	return append([]byte{}, buf[pos:pos+HASHSIZE]...), pos + HASHSIZE, nil
+}
+
+// ReadBytes reads varint length prefixed bytes at pos, which are copied, and returns position after them. Lengths
+// above max are rejected, errors wrap ErrTruncatedProof or ErrMalformedProof. It is shared by graviton decoders
+func ReadBytes(buf []byte, pos int, max uint64) ([]byte, int, error) {
+	length, done, err := ReadUvarint(buf, pos)
+	if err != nil {
+		return nil, pos, err
+	}
+	if length > max {
+		return nil, pos, fmt.Errorf("%w: length %d", ErrMalformedProof, length)
+	}
+	if uint64(len(buf)-done) < length {
+		return nil, pos, fmt.Errorf("%w: %d bytes", ErrTruncatedProof, length)
+	}
+	return append([]byte{}, buf[done:done+int(length)]...), done + int(length), nil
+}
+
+// ReadUvarint reads a minimally encoded uvarint at pos and returns position after it, so as every value has a
+// single encoding. Errors wrap ErrTruncatedProof or ErrMalformedProof
+func ReadUvarint(buf []byte, pos int) (uint64, int, error) {
+	if pos > len(buf) {
+		return 0, pos, fmt.Errorf("%w: varint", ErrTruncatedProof)
+	}
+	value, size := binary.Uvarint(buf[pos:])
+	if size == 0 {
+		return 0, pos, fmt.Errorf("%w: varint", ErrTruncatedProof)
+	}
+	if size < 0 {
+		return 0, pos, fmt.Errorf("%w: varint overflow", ErrMalformedProof)
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	if binary.PutUvarint(tmp[:], value) != size {
+		return 0, pos, fmt.Errorf("%w: varint is not minimally encoded", ErrMalformedProof)
+	}
+	return value, pos + size, nil
+}
@@ -0,0 +1,150 @@
+package verifier
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// vectors are generated from real trees by graviton ( see TestVerifierVectors in graviton )
+type vector struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Root   string `json:"root"`
+	Key    string `json:"key"`
+	Proof  string `json:"proof"`
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func decode(t *testing.T, s string) []byte {
+	buf, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return buf
+}
+
+func TestVectors(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vectors.json")
+	require.NoError(t, err)
+	var vectors []vector
+	require.NoError(t, json.Unmarshal(data, &vectors))
+	require.NotEmpty(t, vectors)
+
+	errs := map[string]error{"truncated": ErrTruncatedProof, "malformed": ErrMalformedProof, "version": ErrProofVersion}
+	kinds := map[string]bool{}
+	for _, v := range vectors {
+		kinds[v.Kind] = true
+		buf := decode(t, v.Proof)
+
+		var proof Proof
+		if v.Kind == "invalid" {
+			require.True(t, errors.Is(proof.Unmarshal(buf), errs[v.Reason]), v.Name)
+			continue
+		}
+		require.NoError(t, proof.Unmarshal(buf), v.Name)
+
+		var root [HASHSIZE]byte
+		copy(root[:], decode(t, v.Root))
+		key, value := decode(t, v.Key), decode(t, v.Value)
+
+		switch v.Kind {
+		case "member":
+			require.True(t, proof.VerifyMembershipUsing(v.Hash, root, key), v.Name)
+			require.Equal(t, value, proof.Value(), v.Name)
+			require.True(t, proof.VerifyValueUsing(v.Hash, value), v.Name)
+		case "memberhash":
+			require.False(t, proof.VerifyMembershipUsing(v.Hash, root, key), v.Name)
+			require.True(t, proof.VerifyMembershipWithValueHashUsing(v.Hash, root, key), v.Name)
+			require.True(t, proof.VerifyValueUsing(v.Hash, value), v.Name)
+			require.False(t, proof.VerifyValueUsing(v.Hash, append(value, 0)), v.Name)
+		case "collision", "deadend":
+			require.True(t, proof.VerifyNonMembershipUsing(v.Hash, root, key), v.Name)
+			require.False(t, proof.VerifyMembershipWithValueHashUsing(v.Hash, root, key), v.Name)
+		default:
+			t.Fatalf("unknown kind %s", v.Kind)
+		}
+
+		// proofs are bound to root, key and hash function
+		root[0] ^= 1
+		require.False(t, proof.VerifyMembershipWithValueHashUsing(v.Hash, root, key) || proof.VerifyNonMembershipUsing(v.Hash, root, key), v.Name)
+		root[0] ^= 1
+		other := HASH_SHA256
+		if v.Hash == HASH_SHA256 {
+			other = HASH_BLAKE2S
+		}
+		require.False(t, proof.VerifyMembershipWithValueHashUsing(other, root, key) || proof.VerifyNonMembershipUsing(other, root, key), v.Name)
+		require.False(t, proof.VerifyMembershipWithValueHashUsing("unknown", root, key))
+	}
+	for _, kind := range []string{"member", "memberhash", "collision", "deadend", "invalid"} {
+		require.True(t, kinds[kind], "no vectors of kind %s", kind)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vectors.json")
+	require.NoError(t, err)
+	var vectors []vector
+	require.NoError(t, json.Unmarshal(data, &vectors))
+
+	for _, v := range vectors {
+		if v.Kind == "invalid" {
+			continue
+		}
+		buf := decode(t, v.Proof)
+		var proof Proof
+		for i := 0; i < len(buf); i++ {
+			require.True(t, errors.Is(proof.Unmarshal(buf[:i]), ErrTruncatedProof), "%s length %d", v.Name, i)
+		}
+	}
+}
+
+func TestRegisterHash(t *testing.T) {
+	require.Error(t, RegisterHash("", nil))
+	require.Error(t, RegisterHash("md5", md5.New)) // only 16 bytes
+
+	// test may run more than once
+	if get_hash("sha256 copy") == nil {
+		require.NoError(t, RegisterHash("sha256 copy", sha256.New))
+	}
+	require.Error(t, RegisterHash("sha256 copy", sha256.New)) // names cannot be replaced
+	require.Error(t, RegisterHash(HASH_SHA256, sha256.New))
+
+	var root [HASHSIZE]byte
+	var proof Proof
+	require.False(t, proof.VerifyMembershipUsing("sha256 copy", root, nil))
+
+	// empty sibling must not be sent as the empty hash
+	zerosHash := leafHash(sha256.New, make([]byte, HASHSIZE), nil)
+	keyhash := make([]byte, HASHSIZE)
+	proof = Proof{ptype: Deadend, trace: [][]byte{nil}}
+	copy(root[:], proof.rootForLeaf(sha256.New, zerosHash, keyhash, zerosHash))
+	valid, member := proof.CheckKeyHash(sha256.New, zerosHash, root, keyhash)
+	require.True(t, valid && !member)
+	proof.trace[0] = zerosHash
+	valid, _ = proof.CheckKeyHash(sha256.New, zerosHash, root, keyhash)
+	require.False(t, valid)
+
+	// hash functions can be registered while proofs are decoded and verified
+	buf := proof.Marshal()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			RegisterHash(fmt.Sprintf("sha256 copy %d", i), sha256.New)
+			var decoded Proof
+			decoded.Unmarshal(buf)
+			decoded.VerifyNonMembershipUsing(HASH_SHA256, root, nil)
+		}(i)
+	}
+	wg.Wait()
+}